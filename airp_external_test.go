@@ -2,10 +2,19 @@ package airp_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"os"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/andreyvit/diff"
 	airp "github.com/d1ced/jsonparser_airp"
@@ -156,6 +165,10 @@ func TestNewJSONGo(t *testing.T) {
 		&[...]uint64{6}, "[6]",
 	}, {
 		[]byte("bytes"), `"bytes"`,
+	}, {
+		uint64(18446744073709551615), "18446744073709551615",
+	}, {
+		float32(0.1), "0.1",
 	}}
 	for _, test := range tests {
 		n, err := airp.NewJSONGo(test.have)
@@ -285,6 +298,20 @@ func TestJSON2Go(t *testing.T) {
 		`{"a":52,"b":420}`,
 		&map[string]int{},
 		map[string]int{"a": 52, "b": 420},
+	}, {
+		`[9007199254740993, 1e2]`, &[]int64{}, []int64{9007199254740993, 100},
+	}, {
+		`{"a":9007199254740993}`,
+		&map[string]int64{},
+		map[string]int64{"a": 9007199254740993},
+	}, {
+		`[[-128, 127], [0, -1]]`, &[][]int8{}, [][]int8{{-128, 127}, {0, -1}},
+	}, {
+		`[65535, 2.55e2]`, &[]uint16{}, []uint16{65535, 255},
+	}, {
+		`-32768`, new(int16), int16(-32768),
+	}, {
+		`[255]`, &[]uint8{}, []uint8{255},
 	}, {
 		`{"a":52,"b":true}`,
 		&struct {
@@ -365,6 +392,33 @@ func TestJSON2Go(t *testing.T) {
 	}
 }
 
+func TestJSON2GoOverflow(t *testing.T) {
+	tests := []struct {
+		have  string
+		store interface{}
+	}{
+		{`1e400`, new(int64)},
+		{`[1e400]`, &[]int64{}},
+		{`[9223372036854775808]`, &[]int64{}},
+		{`{"a":300}`, &map[string]uint8{}},
+		{`128`, new(int8)},
+		{`[-1]`, &[]uint{}},
+		{`[1.5]`, &[]int{}},
+		{`1e39`, new(float32)},
+		{`[1e400]`, &[]float64{}},
+	}
+	for _, test := range tests {
+		n, err := airp.NewJSONString(test.have)
+		if err != nil {
+			t.Fatalf("test setup fail: %v", err)
+		}
+		if err := n.JSON2Go(test.store); err == nil {
+			got := reflect.ValueOf(test.store).Elem().Interface()
+			t.Errorf("%s into %T: want error, got %v", test.have, test.store, got)
+		}
+	}
+}
+
 func TestValue(t *testing.T) {
 	tests := []struct {
 		have string
@@ -374,7 +428,7 @@ func TestValue(t *testing.T) {
 		map[string]interface{}{"a": nil},
 	}, {
 		`[false, -31.2, 5, "ab\"cd"]`,
-		[]interface{}{false, -31.2, 5., "ab\\\"cd"},
+		[]interface{}{false, -31.2, 5., "ab\"cd"},
 	}, {
 		`{"a": 20, "b": [true, null]}`,
 		map[string]interface{}{"a": 20., "b": []interface{}{true, nil}},
@@ -421,3 +475,1654 @@ func TestEscape(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoder(t *testing.T) {
+	input := `{"a": [1, true, null],
+ "b": "x"}`
+	want := []airp.Token{
+		{Kind: airp.DelimKind, Value: "{", Pos: airp.Position{Line: 0, Column: 0, Offset: 0}},
+		{Kind: airp.KeyKind, Value: "a", Pos: airp.Position{Line: 0, Column: 1, Offset: 1}},
+		{Kind: airp.DelimKind, Value: "[", Pos: airp.Position{Line: 0, Column: 6, Offset: 6}},
+		{Kind: airp.NumberKind, Value: "1", Pos: airp.Position{Line: 0, Column: 7, Offset: 7}},
+		{Kind: airp.BoolKind, Value: "true", Pos: airp.Position{Line: 0, Column: 10, Offset: 10}},
+		{Kind: airp.NullKind, Value: "null", Pos: airp.Position{Line: 0, Column: 16, Offset: 16}},
+		{Kind: airp.DelimKind, Value: "]", Pos: airp.Position{Line: 0, Column: 20, Offset: 20}},
+		{Kind: airp.KeyKind, Value: "b", Pos: airp.Position{Line: 1, Column: 1, Offset: 24}},
+		{Kind: airp.StringKind, Value: "x", Pos: airp.Position{Line: 1, Column: 6, Offset: 29}},
+		{Kind: airp.DelimKind, Value: "}", Pos: airp.Position{Line: 1, Column: 9, Offset: 32}},
+	}
+	d := airp.NewDecoder(strings.NewReader(input))
+	for _, w := range want {
+		tk, err := d.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tk != w {
+			t.Errorf("got %v, want %v", tk, w)
+		}
+	}
+	if tk, err := d.Token(); err != io.EOF {
+		t.Errorf("want EOF, got %v, %v", tk, err)
+	}
+}
+
+func TestDecoderMoreSkip(t *testing.T) {
+	d := airp.NewDecoder(strings.NewReader(
+		`[[[]], {"skip": [1, {"x": 2}], "id": 1}, "str", {"id": 2}]`))
+	if tk, err := d.Token(); err != nil || tk.Value != "[" {
+		t.Fatalf("got %v, %v", tk, err)
+	}
+	ids := []string(nil)
+	for d.More() {
+		if err := d.Skip(); err != nil {
+			t.Fatal(err)
+		}
+		if !d.More() {
+			break
+		}
+		if tk, err := d.Token(); err != nil || tk.Value != "{" {
+			t.Fatalf("got %v, %v", tk, err)
+		}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Value != "id" {
+				d.Skip()
+				continue
+			}
+			tk, err := d.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, tk.Value)
+		}
+		if tk, err := d.Token(); err != nil || tk.Value != "}" {
+			t.Fatalf("got %v, %v", tk, err)
+		}
+	}
+	if err := d.Skip(); err != nil {
+		t.Errorf("skip at end of array: %v", err)
+	}
+	if tk, err := d.Token(); err != nil || tk.Value != "]" {
+		t.Fatalf("got %v, %v", tk, err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2"}) {
+		t.Errorf("got %v", ids)
+	}
+	if d.More() {
+		t.Error("more after end of document")
+	}
+	if err := d.Skip(); err != io.EOF {
+		t.Errorf("want EOF, got %v", err)
+	}
+}
+
+func TestDecoderErr(t *testing.T) {
+	tests := []struct {
+		have  string
+		row   int
+		col   int
+		count int
+	}{
+		{`{"a" 1}`, 0, 5, 2},
+		{`[1 2]`, 0, 3, 2},
+		{`[1,]`, 0, 3, 2},
+		{`{"a":[}`, 0, 6, 3},
+		{`[1}`, 0, 2, 2},
+		{`{"a":1`, 0, 5, 3},
+		{`1 2`, 0, 2, 1},
+		{`[-]`, 0, 1, 1},
+	}
+	for _, test := range tests {
+		d := airp.NewDecoder(strings.NewReader(test.have))
+		var err error
+		count := 0
+		for err == nil {
+			_, err = d.Token()
+			count++
+		}
+		pErr, ok := err.(*airp.ParseError)
+		if !ok {
+			t.Errorf("%s: want ParseError, got %v", test.have, err)
+			continue
+		}
+		if row, col := pErr.Where(); row != test.row || col != test.col || count-1 != test.count {
+			t.Errorf("%s: got %d:%d after %d tokens, want %d:%d after %d (%v)",
+				test.have, row, col, count-1, test.row, test.col, test.count, err)
+		}
+		if _, err2 := d.Token(); err2 != err {
+			t.Errorf("%s: error not sticky", test.have)
+		}
+	}
+}
+
+func TestNumberRoundTrip(t *testing.T) {
+	tests := []string{
+		`9007199254740993`,
+		`[0.1000000000000000000000001,-0,1E400,2.50e-3]`,
+		`{"id":18446744073709551616,"price":19.990000000000000001}`,
+	}
+	for _, test := range tests {
+		n, err := airp.NewJSONString(test)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.String() != test {
+			t.Errorf("got %s, want %s", n, test)
+		}
+	}
+}
+
+func TestNumberAccessors(t *testing.T) {
+	n, err := airp.NewJSONString(`[9007199254740993, 18446744073709551615, 1.5e3, 0.1]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := n.GetChild("0")
+	num, err := m.Number()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i, err := num.Int64(); err != nil || i != 9007199254740993 {
+		t.Errorf("Int64: got %d, %v", i, err)
+	}
+	m, _ = n.GetChild("1")
+	num, _ = m.Number()
+	if u, err := num.Uint64(); err != nil || u != 18446744073709551615 {
+		t.Errorf("Uint64: got %d, %v", u, err)
+	}
+	if _, err := num.Int64(); err == nil {
+		t.Error("Int64: want overflow error")
+	}
+	m, _ = n.GetChild("2")
+	num, _ = m.Number()
+	if b, err := num.BigInt(); err != nil || b.Int64() != 1500 {
+		t.Errorf("BigInt: got %v, %v", b, err)
+	}
+	if f, err := num.Float64(); err != nil || f != 1500 {
+		t.Errorf("Float64: got %v, %v", f, err)
+	}
+	m, _ = n.GetChild("3")
+	num, _ = m.Number()
+	if _, err := num.BigInt(); err == nil {
+		t.Error("BigInt: want error for fraction")
+	}
+	if f, err := num.BigFloat(); err != nil || f.Text('g', 10) != "0.1" {
+		t.Errorf("BigFloat: got %v, %v", f, err)
+	}
+	if _, err := n.Number(); err == nil {
+		t.Error("Number on array: want error")
+	}
+}
+
+func TestInvalidNumber(t *testing.T) {
+	for _, test := range []string{`01`, `1.`, `.5`, `+1`, `1e`, `--1`, `1.e5`, `-`, `1e+-5`} {
+		if _, err := airp.NewJSONString(test); err == nil {
+			t.Errorf("%s: want error", test)
+		}
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	keys := []string{"2fa", "content type", "", "$ref", "@id", "web-app"}
+	for _, key := range keys {
+		data := `{"` + key + `": true}`
+		n, err := airp.NewJSONString(data)
+		if err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if n.GetChildrenKeys()[0] != key {
+			t.Errorf("got key %s, want %s", n.GetChildrenKeys()[0], key)
+		}
+		_, err = airp.ParseOptions{ValidateKey: airp.IdentifierKeys}.NewJSONString(data)
+		if (err == nil) != (key == "web-app" || key == "") {
+			t.Errorf("%s: identifier keys: unexpected error %v", data, err)
+		}
+	}
+
+	errReserved := errors.New("reserved key")
+	opts := airp.ParseOptions{ValidateKey: func(key string) error {
+		if strings.HasPrefix(key, "$") {
+			return errReserved
+		}
+		return nil
+	}}
+	_, err := opts.NewJSONString(`{"a": {"$ref": 1}}`)
+	if _, ok := err.(*airp.ParseError); !ok || !errors.Is(err, errReserved) {
+		t.Errorf("want ParseError wrapping validator error, got %v", err)
+	}
+	d := opts.NewDecoder(strings.NewReader(`{"$ref": 1}`))
+	d.Token()
+	if _, err := d.Token(); !errors.Is(err, errReserved) {
+		t.Errorf("decoder: want validator error, got %v", err)
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	input := `{"a":1,"b":{"x":true,"x":false},"a":3}`
+	tests := []struct {
+		policy airp.DuplicatePolicy
+		want   string
+		a      string
+		x      bool
+	}{
+		{airp.DuplicateLastWins, `{"a":3,"b":{"x":false}}`, "3", false},
+		{airp.DuplicateFirstWins, `{"a":1,"b":{"x":true}}`, "1", true},
+		{airp.DuplicateKeepAll, input, "3", false},
+	}
+	if _, err := airp.NewJSONString(input); err == nil {
+		t.Error("want error for duplicate keys by default")
+	}
+	for _, test := range tests {
+		n, err := airp.ParseOptions{Duplicates: test.policy}.NewJSONString(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.String() != test.want {
+			t.Errorf("%d: got %s, want %s", test.policy, n, test.want)
+		}
+		if a, _ := n.GetChild("a"); a.String() != test.a {
+			t.Errorf("%d: GetChild got %s, want %s", test.policy, a, test.a)
+		}
+		v, _ := n.Value()
+		if x := v.(map[string]interface{})["b"].(map[string]interface{})["x"]; x != test.x {
+			t.Errorf("%d: Value got %v, want %v", test.policy, x, test.x)
+		}
+		if !airp.EqNode(n, n.Copy()) {
+			t.Errorf("%d: copy not equal", test.policy)
+		}
+		if x, _ := n.GetChild("b.x"); x.Key() != "b.x" {
+			t.Errorf("%d: got key %s", test.policy, x.Key())
+		}
+	}
+
+	a, _ := airp.ParseOptions{Duplicates: airp.DuplicateKeepAll}.NewJSONString(`{"a":1,"a":2}`)
+	b, _ := airp.ParseOptions{Duplicates: airp.DuplicateKeepAll}.NewJSONString(`{"a":2,"a":1}`)
+	c, _ := airp.NewJSONString(`{"a":2,"b":2}`)
+	if airp.EqNode(a, b) || airp.EqNode(b, c) {
+		t.Error("objects with different duplicates compare equal")
+	}
+}
+
+func TestStringDecoding(t *testing.T) {
+	tests := []struct {
+		have, want string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"\"\\\/\b\f\n\r\t"`, "\"\\/\b\f\n\r\t"},
+		{`"\u00e4\u00C4"`, "äÄ"},
+		{`"\ud83d\ude00!"`, "\U0001f600!"},
+		{`"\ud83d"`, "\ufffd"},
+		{`"\ude00x"`, "\ufffdx"},
+		{`"\ud83dA"`, "\ufffdA"},
+		{`"\ud83d\ud83d\ude00"`, "\ufffd\U0001f600"},
+	}
+	for _, test := range tests {
+		n, err := airp.NewJSONString(test.have)
+		if err != nil {
+			t.Errorf("%s: %v", test.have, err)
+			continue
+		}
+		if v, _ := n.Value(); v != test.want {
+			t.Errorf("%s: got %q, want %q", test.have, v, test.want)
+		}
+	}
+
+	strict := airp.ParseOptions{Surrogates: airp.SurrogateError}
+	if _, err := strict.NewJSONString(`"\ud83d\ude00"`); err != nil {
+		t.Errorf("surrogate pair rejected: %v", err)
+	}
+	_, err := strict.NewJSONString(`["ok", "x\ud83dA"]`)
+	pErr, ok := err.(*airp.ParseError)
+	if !ok {
+		t.Fatalf("want ParseError for lone surrogate, got %v", err)
+	}
+	if row, col := pErr.Where(); row != 0 || col != 9 {
+		t.Errorf("got position %d:%d, want 0:9", row, col)
+	}
+	for _, s := range []string{`"\ud800"`, `"\ud800A"`, `"\ud800\u0041"`, `"\udc00"`, `"x\ude00y"`} {
+		if _, err := strict.NewJSONString(s); !errors.Is(err, airp.ErrInvalidEscape) {
+			t.Errorf("%s: want invalid escape, got %v", s, err)
+		}
+	}
+	if _, err := strict.NewJSONString(`"\ud800\u00`); !errors.Is(err, airp.ErrInvalidEscape) {
+		t.Errorf("lone surrogate before end of input: got %v", err)
+	}
+	if _, err := strict.NewJSONString(`"\ud8`); !errors.Is(err, airp.ErrUnexpectedEOF) {
+		t.Errorf("escape cut off: want unexpected end of input, got %v", err)
+	}
+}
+
+func TestStringEscaping(t *testing.T) {
+	tests := []struct {
+		have string
+		mode airp.EscapeMode
+		want string
+	}{
+		{"plain", 0, `"plain"`},
+		{"q\"b\\s/", 0, `"q\"b\\s/"`},
+		{"\b\f\n\r\t\x00\x1f", 0, `"\b\f\n\r\t\u0000\u001f"`},
+		{"\u00e4\U0001f600", 0, "\"\u00e4\U0001f600\""},
+		{"\u00e4\U0001f600", airp.EscapeASCII, `"\u00e4\ud83d\ude00"`},
+		{"<a&b>\u2028\u2029", 0, "\"<a&b>\u2028\u2029\""},
+		{"<a&b>\u2028\u2029", airp.EscapeHTML, `"\u003ca\u0026b\u003e\u2028\u2029"`},
+		{"x\xffy", 0, `"x\ufffdy"`},
+	}
+	for _, test := range tests {
+		n, err := airp.NewJSONGo(map[string]string{test.have: test.have})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &strings.Builder{}
+		if _, err := n.WriteEscaped(b, "", test.mode); err != nil {
+			t.Fatal(err)
+		}
+		if want := "{" + test.want + ":" + test.want + "}"; b.String() != want {
+			t.Errorf("got %s, want %s", b, want)
+		}
+		if test.mode != 0 {
+			continue
+		}
+		m, err := airp.NewJSONString(n.String())
+		if err != nil {
+			t.Errorf("output not parsable: %v", err)
+		} else if !airp.EqNode(n, m) && !strings.Contains(test.have, "\xff") {
+			t.Errorf("round trip: got %s, want %s", m, n)
+		}
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	n, err := airp.NewJSONString(`{"b":[1.50,{},[]],"a":{"z":"<x>","y":1E2}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		opts airp.EncodeOptions
+		want string
+	}{{
+		airp.EncodeOptions{},
+		`{"b":[1.50,{},[]],"a":{"z":"<x>","y":1E2}}`,
+	}, {
+		airp.EncodeOptions{SpaceAfterColon: true, SpaceAfterComma: true},
+		`{"b": [1.50, {}, []], "a": {"z": "<x>", "y": 1E2}}`,
+	}, {
+		airp.EncodeOptions{SortKeys: true, Numbers: airp.NumberFloat64,
+			Escape: airp.EscapeHTML, TrailingNewline: true},
+		`{"a":{"y":100,"z":"\u003cx\u003e"},"b":[1.5,{},[]]}` + "\n",
+	}, {
+		airp.EncodeOptions{Indent: "\t", Prefix: "//", SpaceAfterColon: true, SpaceAfterComma: true},
+		"{\n//\t\"b\": [\n//\t\t1.50,\n//\t\t{},\n//\t\t[]\n//\t],\n//\t\"a\": {\n" +
+			"//\t\t\"z\": \"<x>\",\n//\t\t\"y\": 1E2\n//\t}\n//}",
+	}}
+	for i, test := range tests {
+		b := &strings.Builder{}
+		c, err := n.Encode(b, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want || c != len(test.want) {
+			t.Errorf("%d: got %d bytes\n%s\nwant\n%s", i, c, b, test.want)
+		}
+	}
+	if got := n.GetChildrenKeys(); got[0] != "b" {
+		t.Errorf("SortKeys changed the node: %v", got)
+	}
+
+	b := &strings.Builder{}
+	e := airp.NewEncoder(b, airp.EncodeOptions{TrailingNewline: true})
+	for _, s := range []string{`[1, 2]`, `{"a": null}`} {
+		m, _ := airp.NewJSONString(s)
+		if err := e.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.String() != "[1,2]\n{\"a\":null}\n" {
+		t.Errorf("encoder got %q", b)
+	}
+
+	// the values stay apart without options
+	b.Reset()
+	e = airp.NewEncoder(b, airp.EncodeOptions{})
+	for _, s := range []string{`1`, `2`, `"x"`} {
+		m, _ := airp.NewJSONString(s)
+		e.Encode(m)
+	}
+	var got []string
+	p := airp.NewPushParser(func(m *airp.Node) { got = append(got, m.String()) })
+	p.Write([]byte(b.String()))
+	if _, err := p.Close(); err != nil || !reflect.DeepEqual(got, []string{`1`, `2`, `"x"`}) {
+		t.Errorf("encoder got %q, read back %q, %v", b, got, err)
+	}
+}
+
+func TestEncodeWidth(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts airp.EncodeOptions
+		want string
+	}{{
+		`{"name":"route","points":[[1,2],[3,4],[5,6]],"tags":["a","b"],` +
+			`"meta":{"long":"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}}`,
+		airp.EncodeOptions{Indent: "  ", SpaceAfterColon: true, Width: 30},
+		"{\n  \"name\": \"route\",\n  \"points\": [\n    [1, 2],\n    [3, 4],\n" +
+			"    [5, 6]\n  ],\n  \"tags\": [\"a\", \"b\"],\n  \"meta\": {\n" +
+			"    \"long\": \"xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx\"\n  }\n}",
+	}, {
+		`[1,2]`,
+		airp.EncodeOptions{Indent: "  ", Width: 6},
+		`[1, 2]`,
+	}, {
+		`[1,2]`,
+		airp.EncodeOptions{Indent: "  ", Width: 5},
+		"[\n  1,\n  2\n]",
+	}, {
+		`[[],{"ä":"ö"}]`,
+		airp.EncodeOptions{Indent: "\t", Width: 16},
+		`[[], {"ä":"ö"}]`,
+	}, {
+		`[1,2]`,
+		airp.EncodeOptions{Width: 80},
+		`[1,2]`,
+	}}
+	for i, test := range tests {
+		n, err := airp.NewJSONString(test.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &strings.Builder{}
+		if _, err := n.Encode(b, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != test.want {
+			t.Errorf("%d: got\n%s\nwant\n%s", i, b, test.want)
+		}
+	}
+}
+
+func TestJSON5(t *testing.T) {
+	opts := airp.ParseOptions{Syntax: airp.SyntaxJSON5}
+	tests := []struct {
+		json5 string
+		json  string
+	}{{
+		"// config\n{/* inline */ a: 1, /* a\nb */ $b_2: [1, 2,],}",
+		`{"a": 1, "$b_2": [1, 2]}`,
+	}, {
+		`{'single': 'it\'s "quoted"', null: true, Infinity: false}`,
+		`{"single": "it's \"quoted\"", "null": true, "Infinity": false}`,
+	}, {
+		`[0x1F, -0XfF, .5, 5., +1, 1.e2, 0x10000000000000000]`,
+		`[31, -255, 0.5, 5, 1, 1e2, 18446744073709551616]`,
+	}, {
+		"'multi\\\nline\\\r\nstring'",
+		`"multilinestring"`,
+	}, {
+		`'\x41\v\0\q'`,
+		`"A\u000b\u0000q"`,
+	}, {
+		"\ufeff[\u00a0null\u2028]",
+		`[null]`,
+	}, {
+		`{"a":1}`,
+		`{"a":1}`,
+	}}
+	for _, test := range tests {
+		n, err := opts.NewJSONString(test.json5)
+		if err != nil {
+			t.Errorf("%s: %v", test.json5, err)
+			continue
+		}
+		m, err := airp.NewJSONString(test.json)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !airp.EqNode(n, m) {
+			t.Errorf("%s: got %s, want %s", test.json5, n, m)
+		}
+	}
+
+	n, err := opts.NewJSONString(`[Infinity, -Infinity, +NaN]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &strings.Builder{}
+	if _, err := n.Encode(b, airp.EncodeOptions{JSON5: true}); err != nil || b.String() != `[Infinity,-Infinity,NaN]` {
+		t.Errorf("non-finite numbers: got %s, %v", b, err)
+	}
+	if _, err := n.MarshalJSON(); err == nil {
+		t.Error("non-finite numbers: want error for JSON")
+	}
+	if _, err := n.WriteJSON(io.Discard); err == nil {
+		t.Error("non-finite numbers: want error for JSON")
+	}
+	if v, _ := n.Value(); !math.IsInf(v.([]interface{})[1].(float64), -1) {
+		t.Errorf("non-finite numbers: got %v", v)
+	}
+
+	for _, s := range []string{
+		`[1,,]`, `[,]`, `{,}`, `{a b: 1}`, `{1a: 1}`, `[0x]`, `[.]`, `[.e1]`,
+		`[01]`, `[infinity]`, `[abc]`, `'\1'`, `/* open`, `/ comment`, `'\x4'`,
+	} {
+		if _, err := opts.NewJSONString(s); err == nil {
+			t.Errorf("%s: want error", s)
+		}
+	}
+	for _, s := range []string{`[1,]`, `{a: 1}`, `['a']`, `[+1]`, `[1]//`} {
+		if _, err := airp.NewJSONString(s); err == nil {
+			t.Errorf("%s: want error without JSON5", s)
+		}
+	}
+}
+
+func TestDecoderJSON5(t *testing.T) {
+	d := airp.ParseOptions{Syntax: airp.SyntaxJSON5}.NewDecoder(
+		strings.NewReader(`{a: [0x10, NaN,], 'b': .5, /* end */}`))
+	var got []string
+	for {
+		tk, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tk.Value)
+	}
+	want := []string{"{", "a", "[", "16", "NaN", "]", "b", "0.5", "}"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecoderMoreTrailingComma(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{`[1,]`, []string{"[", "1", "]"}},
+		{`{"a":1,}`, []string{"{", "a", "1", "}"}},
+		{`[[],{"b":[2,],},]`, []string{"[", "[", "]", "{", "b", "[", "2", "]", "}", "]"}},
+	}
+	for _, syntax := range []airp.Syntax{airp.SyntaxJSON5, airp.SyntaxJSONC} {
+		for _, tt := range tests {
+			d := airp.ParseOptions{Syntax: syntax}.NewDecoder(strings.NewReader(tt.in))
+			var got []string
+			var walk func() error
+			walk = func() error {
+				tk, err := d.Token()
+				if err != nil {
+					return err
+				}
+				got = append(got, tk.Value)
+				if tk.Value != "[" && tk.Value != "{" {
+					return nil
+				}
+				for d.More() {
+					if tk.Value == "{" {
+						key, err := d.Token()
+						if err != nil {
+							return err
+						}
+						got = append(got, key.Value)
+					}
+					if err := walk(); err != nil {
+						return err
+					}
+				}
+				tk, err = d.Token()
+				got = append(got, tk.Value)
+				return err
+			}
+			if err := walk(); err != nil {
+				t.Errorf("%s: %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s: got %q, want %q", tt.in, got, tt.want)
+			}
+			if _, err := d.Token(); err != io.EOF {
+				t.Errorf("%s: want EOF, got %v", tt.in, err)
+			}
+		}
+	}
+}
+
+func TestJSONC(t *testing.T) {
+	src := `// settings
+{
+	// font
+	"fontSize": 14, // px
+	"files.exclude": {
+		"**/.git": true /* vcs */
+		// more here
+	},
+	"list": [
+		1, /* one */
+		2
+	],
+	"empty": {
+		/* nothing */
+	}
+} // end`
+	opts := airp.ParseOptions{Syntax: airp.SyntaxJSONC}
+	n, err := opts.NewJSONString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &strings.Builder{}
+	n.WriteIndent(b, "\t")
+	if b.String() != src {
+		t.Errorf("round trip mismatch:\n%s", diff.LineDiff(b.String(), src))
+	}
+
+	m, _ := n.GetChild("fontSize")
+	if got := m.Comments(airp.LeadingComment); !reflect.DeepEqual(got, []string{"// font"}) {
+		t.Errorf("leading comments: got %q", got)
+	}
+	if got := m.Comments(airp.TrailingComment); !reflect.DeepEqual(got, []string{"// px"}) {
+		t.Errorf("trailing comments: got %q", got)
+	}
+	m, _ = n.GetChild("empty")
+	if got := m.Comments(airp.InnerComment); !reflect.DeepEqual(got, []string{"/* nothing */"}) {
+		t.Errorf("inner comments: got %q", got)
+	}
+
+	if _, err := opts.NewJSONString(`[1, 2,] // trailing comma`); err != nil {
+		t.Error(err)
+	}
+	// a comment after the bracket following a trailing comma
+	for _, have := range []string{"{\"a\":1,} // t", "[1,] // t",
+		"{\"a\":{\"b\":1,} // t\n}", "[[1,] // t\n]", "{\"a\":[1,] // t\n}"} {
+		for _, o := range []airp.ParseOptions{opts, {Syntax: airp.SyntaxJSONC, Lossless: true}} {
+			if _, err := o.NewJSONString(have); err != nil {
+				t.Errorf("%q: %v", have, err)
+			}
+		}
+		p := opts.NewPushParser(nil)
+		p.Write([]byte(have))
+		if _, err := p.Close(); err != nil {
+			t.Errorf("%q: push got %v", have, err)
+		}
+		if _, err := opts.NewStreamDecoder(strings.NewReader(have), airp.FramingConcat).Next(); err != nil {
+			t.Errorf("%q: stream got %v", have, err)
+		}
+	}
+	if _, err := airp.NewJSONString(`[1] // no comments in JSON`); err == nil {
+		t.Error("comment in JSON: want error")
+	}
+	if _, err := opts.NewJSONString(`[1] /* open`); err == nil {
+		t.Error("open comment: want error")
+	}
+}
+
+func TestComments(t *testing.T) {
+	n, _ := airp.NewJSONString(`{"a":[1],"b":2}`)
+	a, _ := n.GetChild("a")
+	for _, c := range []struct {
+		where airp.CommentPlacement
+		text  string
+	}{
+		{airp.LeadingComment, "// lead"},
+		{airp.TrailingComment, "/* trail */"},
+		{airp.InnerComment, "// inner"},
+	} {
+		if err := a.AddComment(c.where, c.text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []string{"no comment", "// two\nlines", "/* a */ /* b */", "/* open"} {
+		if err := a.AddComment(airp.LeadingComment, c); err == nil {
+			t.Errorf("%q: want error", c)
+		}
+	}
+	b, _ := n.GetChild("b")
+	if err := b.AddComment(airp.InnerComment, "// inner"); err == nil {
+		t.Error("inner comment of number: want error")
+	}
+
+	want := "{\n  // lead\n  \"a\": [\n    1\n    // inner\n  ], /* trail */\n  \"b\": 2\n}"
+	buf := &strings.Builder{}
+	n.Copy().Encode(buf, airp.EncodeOptions{})
+	if got := buf.String(); got != "{// lead\n\"a\":[1// inner\n], /* trail */\"b\":2}" {
+		t.Errorf("compact: got %s", got)
+	}
+	if got := n.String(); got != `{"a":[1],"b":2}` {
+		t.Errorf("String: got %s", got)
+	}
+	buf.Reset()
+	n.WriteJSON(buf)
+	if buf.String() != `{"a":[1],"b":2}` {
+		t.Errorf("WriteJSON: got %s", buf)
+	}
+	buf.Reset()
+	n.Encode(buf, airp.EncodeOptions{Indent: "  ", SpaceAfterColon: true, Width: 80})
+	if buf.String() != want {
+		t.Errorf("indented: got\n%s\nwant\n%s", buf, want)
+	}
+	buf.Reset()
+	n.Encode(buf, airp.EncodeOptions{StripComments: true})
+	if buf.String() != `{"a":[1],"b":2}` {
+		t.Errorf("stripped: got %s", buf)
+	}
+	if j, _ := n.MarshalJSON(); string(j) != `{"a": [1], "b": 2}` {
+		t.Errorf("MarshalJSON: got %s", j)
+	}
+
+	a.RemoveComments(airp.LeadingComment)
+	a.RemoveComments(airp.InnerComment)
+	a.RemoveComments(airp.TrailingComment)
+	if n.String() != `{"a":[1],"b":2}` || a.Comments(airp.TrailingComment) != nil {
+		t.Errorf("comments not removed: %s", n)
+	}
+}
+
+func TestLossless(t *testing.T) {
+	opts := airp.ParseOptions{Lossless: true}
+	for _, src := range []string{
+		"  {\"a\" : [ 1.0e+2,\"\\u00e4\\/\" ],\r\n\"b\":{ } }\n\n",
+		`"\ud83d\ude00"`,
+		"[\n  -0,\n  1E400\n]",
+	} {
+		n, err := opts.NewJSONReader(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &strings.Builder{}
+		n.Encode(b, airp.EncodeOptions{Preserve: true, Indent: "\t"})
+		if b.String() != src {
+			t.Errorf("got %q, want %q", b, src)
+		}
+	}
+	for _, src := range []string{
+		"// c\n{a: 0x10, /* x */ 'b': [.5,],}\n",
+		"{\n\t// c\n\t\"a\": 1, // d\n}",
+	} {
+		n, err := airp.ParseOptions{Syntax: airp.SyntaxJSON5, Lossless: true}.NewJSONString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := &strings.Builder{}
+		n.Encode(b, airp.EncodeOptions{Preserve: true})
+		if b.String() != src {
+			t.Errorf("got %q, want %q", b, src)
+		}
+	}
+
+	src := `{
+    "name" :  "caf\u00e9",
+    "port": 8080, // default
+    "hosts": ["a", "b"],
+    "tls": {
+        "cert": "x.pem"
+    }
+}
+`
+	n, err := airp.ParseOptions{Syntax: airp.SyntaxJSONC, Lossless: true}.NewJSONString(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.SetChild(airp.StandaloneNode("port", "8443"))
+	hosts, _ := n.GetChild("hosts")
+	hosts.RemoveChild("0")
+	hosts.AddChildren(airp.StandaloneNode("", `"c"`))
+	tls, _ := n.GetChild("tls")
+	key, _ := airp.NewJSONString(`{"file":"x.key","mode":[4,0,0]}`)
+	tls.AddChildren(airp.KeyNode{Key: "key", Node: key})
+	want := `{
+    "name" :  "caf\u00e9",
+    "port": 8443, // default
+    "hosts": ["b", "c"],
+    "tls": {
+        "cert": "x.pem",
+        "key": {
+            "file": "x.key",
+            "mode": [
+                4,
+                0,
+                0
+            ]
+        }
+    }
+}
+`
+	b := &strings.Builder{}
+	n.Encode(b, airp.EncodeOptions{Preserve: true})
+	if b.String() != want {
+		t.Errorf("edited:\n%s", diff.LineDiff(b.String(), want))
+	}
+	b.Reset()
+	n.Copy().Encode(b, airp.EncodeOptions{Preserve: true})
+	if b.String() != want {
+		t.Errorf("copy:\n%s", diff.LineDiff(b.String(), want))
+	}
+
+	n, err = opts.NewJSONString(`{"x": "\u0041", "a": [1]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := n.GetChild("a")
+	a.AddChildren(airp.StandaloneNode("", "2"))
+	b.Reset()
+	n.Encode(b, airp.EncodeOptions{Preserve: true})
+	if want := `{"x": "\u0041", "a": [1, 2]}`; b.String() != want {
+		t.Errorf("one element: got %s, want %s", b, want)
+	}
+	n, err = opts.NewJSONString(`{"x": "\u0041"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddChildren(airp.StandaloneNode("y", "true"))
+	b.Reset()
+	n.Encode(b, airp.EncodeOptions{Preserve: true})
+	if want := `{"x": "\u0041", "y": true}`; b.String() != want {
+		t.Errorf("one member: got %s, want %s", b, want)
+	}
+
+	n, err = airp.ParseOptions{Lossless: true, Duplicates: airp.DuplicateLastWins}.
+		NewJSONString(`{"a": 1, "b": 2, "a": 3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	n.Encode(b, airp.EncodeOptions{Preserve: true})
+	if b.String() != `{"a": 3, "b": 2}` {
+		t.Errorf("duplicates: got %s", b)
+	}
+}
+
+func TestSpan(t *testing.T) {
+	n, err := airp.NewJSONString("{\"a\": [1, \"xy\"],\n  \"b\": {\"c\": null}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := func(line, col, off int) airp.Position {
+		return airp.Position{Line: line, Column: col, Offset: off}
+	}
+	tests := []struct {
+		name     string
+		span     airp.Span
+		key      airp.Span
+		memberOk bool
+	}{
+		{"", airp.Span{Start: pos(0, 0, 0), End: pos(1, 19, 36)}, airp.Span{}, false},
+		{"a", airp.Span{Start: pos(0, 6, 6), End: pos(0, 15, 15)}, airp.Span{Start: pos(0, 1, 1), End: pos(0, 4, 4)}, true},
+		{"a.1", airp.Span{Start: pos(0, 10, 10), End: pos(0, 14, 14)}, airp.Span{}, false},
+		{"b", airp.Span{Start: pos(1, 7, 24), End: pos(1, 18, 35)}, airp.Span{Start: pos(1, 2, 19), End: pos(1, 5, 22)}, true},
+		{"b.c", airp.Span{Start: pos(1, 13, 30), End: pos(1, 17, 34)}, airp.Span{Start: pos(1, 8, 25), End: pos(1, 11, 28)}, true},
+	}
+	for _, test := range tests {
+		m, ok := n.GetChild(test.name)
+		if !ok {
+			t.Fatalf("%q not found", test.name)
+		}
+		if s, ok := m.Span(); !ok || s != test.span {
+			t.Errorf("%q: got span %v, %v, want %v", test.name, s, ok, test.span)
+		}
+		if s, ok := m.KeySpan(); ok != test.memberOk || s != test.key {
+			t.Errorf("%q: got key span %v, %v, want %v", test.name, s, ok, test.key)
+		}
+	}
+	m, _ := airp.NewJSONGo([]int{1})
+	if _, ok := m.Span(); ok {
+		t.Error("span of constructed node")
+	}
+	n.AddChildren(airp.KeyNode{Key: "d", Node: m})
+	if _, ok := n.Span(); !ok {
+		t.Error("lost span after change")
+	}
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	long := strings.Repeat(`"abcdefgh",`, 1000)
+	tests := []struct {
+		have    string
+		kind    error
+		offset  int
+		snippet string
+	}{
+		{`{"a": nul}`, airp.ErrUnexpectedToken, 6, "{\"a\": nul}\n      ^"},
+		{"[1,\n\t2 3]", airp.ErrUnexpectedToken, 7, "\t2 3]\n\t  ^"},
+		{`{"a": [1,`, airp.ErrUnexpectedEOF, 9, "{\"a\": [1,\n         ^"},
+		{`["abc`, airp.ErrUnexpectedEOF, 1, "[\"abc\n ^"},
+		{`["ä\q"]`, airp.ErrInvalidEscape, 4, "[\"ä\\q\"]\n   ^"},
+		{`[01]`, airp.ErrInvalidNumber, 1, "[01]\n ^"},
+		{`{"a":1,"a":2}`, airp.ErrDuplicateKey, 7, "{\"a\":1,\"a\":2}\n       ^"},
+		{"[" + long + "x]", airp.ErrUnexpectedToken, 11001, long[len(long)-64:] + "x]\n" + strings.Repeat(" ", 64) + "^"},
+		{strings.Repeat("[", 10001), airp.ErrDepthExceeded, 10000, strings.Repeat("[", 65) + "\n" + strings.Repeat(" ", 64) + "^"},
+	}
+	for _, test := range tests {
+		_, err := airp.ParseOptions{Duplicates: airp.DuplicateError}.NewJSONReader(strings.NewReader(test.have))
+		var pErr *airp.ParseError
+		if !errors.As(err, &pErr) || !errors.Is(err, test.kind) {
+			t.Errorf("%s: got %v, want %v", test.have, err, test.kind)
+			continue
+		}
+		if pErr.Offset() != test.offset {
+			t.Errorf("%s: got offset %d, want %d", test.have, pErr.Offset(), test.offset)
+		}
+		if pErr.Snippet() != test.snippet {
+			t.Errorf("%s: got snippet\n%s\nwant\n%s", test.have, pErr.Snippet(), test.snippet)
+		}
+
+		if test.kind == airp.ErrDuplicateKey {
+			continue // the decoder keeps duplicates
+		}
+		d := airp.NewDecoder(strings.NewReader(test.have))
+		for err == nil || err == pErr {
+			_, err = d.Token()
+		}
+		if !errors.As(err, &pErr) || !errors.Is(err, test.kind) {
+			t.Errorf("%s: decoder got %v, want %v", test.have, err, test.kind)
+		} else if pErr.Offset() != test.offset || pErr.Snippet() != test.snippet {
+			t.Errorf("%s: decoder got %d\n%s", test.have, pErr.Offset(), pErr.Snippet())
+		}
+	}
+}
+
+func TestTolerant(t *testing.T) {
+	tests := []struct {
+		have string
+		want string
+		errs []airp.Position // Line and Column of the errors
+		kind error
+	}{
+		{`[1, nul, 2]`, `[1,<error>,2]`, []airp.Position{{Line: 0, Column: 4}}, airp.ErrUnexpectedToken},
+		{`{"a" 1, "b": 2}`, `{"a":<error>,"b":2}`, []airp.Position{{Line: 0, Column: 5}}, airp.ErrUnexpectedToken},
+		{`{1: 2, "c": 3}`, `{"c":3}`, []airp.Position{{Line: 0, Column: 1}}, airp.ErrUnexpectedToken},
+		{`[1 2, 3]`, `[1,3]`, []airp.Position{{Line: 0, Column: 3}}, airp.ErrUnexpectedToken},
+		{`[1,]`, `[1]`, []airp.Position{{Line: 0, Column: 3}}, airp.ErrUnexpectedToken},
+		{`{"a":1,,"b":2}`, `{"a":1,"b":2}`, []airp.Position{{Line: 0, Column: 7}}, airp.ErrUnexpectedToken},
+		{"[\"\\q\",\n \"ok\", 01]", `[<error>,"ok",<error>]`,
+			[]airp.Position{{Line: 0, Column: 2}, {Line: 1, Column: 7}}, airp.ErrInvalidNumber},
+		{`{"a":1,"a":2}`, `{"a":1,"a":2}`, []airp.Position{{Line: 0, Column: 7}}, airp.ErrDuplicateKey},
+		{`{"a": [1, {"b": 2`, `{"a":[1,{"b":2}]}`, []airp.Position{{Line: 0, Column: 16}}, airp.ErrUnexpectedEOF},
+		{`{"a":`, `{"a":<error>}`, []airp.Position{{Line: 0, Column: 5}}, airp.ErrUnexpectedEOF},
+		{`{"i":[{"j":[null]}}]`, `{"i":[{"j":[null]}]}`,
+			[]airp.Position{{Line: 0, Column: 18}, {Line: 0, Column: 19}}, airp.ErrUnexpectedToken},
+		{`null 5 [1`, `null`, []airp.Position{{Line: 0, Column: 5}}, airp.ErrUnexpectedToken},
+		{`[1, {"a" : [}, 3]`, `[1,{"a":[<error>]},3]`, []airp.Position{{Line: 0, Column: 12}}, airp.ErrUnexpectedToken},
+		{`[1, {"a": 2], 3`, `[1,{"a":2}]`, []airp.Position{{Line: 0, Column: 11}, {Line: 0, Column: 12}}, airp.ErrUnexpectedToken},
+		{`[[[}]]]`, `[[[<error>]]]`, []airp.Position{{Line: 0, Column: 3}}, airp.ErrUnexpectedToken},
+		{`{"a": [1}, "b": 2}`, `{"a":[1]}`, []airp.Position{{Line: 0, Column: 8}, {Line: 0, Column: 9}}, airp.ErrUnexpectedToken},
+		{``, `<error>`, []airp.Position{{Line: 0, Column: 0}}, airp.ErrUnexpectedEOF},
+		{"{x \"a\": 1, // t\n}", `{}`, []airp.Position{{Line: 0, Column: 1}}, airp.ErrUnexpectedToken},
+		{"{1: 2, // t\n\"b\":3}", `{"b":3}`, []airp.Position{{Line: 0, Column: 1}}, airp.ErrUnexpectedToken},
+		{"{\"a\": 1, x: 2, // t\n\"b\":3}", `{"a":1,"b":3}`, []airp.Position{{Line: 0, Column: 9}}, airp.ErrUnexpectedToken},
+		{`[true, false]`, `[true,false]`, nil, nil},
+	}
+	for _, test := range tests {
+		o := airp.ParseOptions{Tolerant: true}
+		if strings.Contains(test.have, "//") {
+			o.Syntax = airp.SyntaxJSONC
+		}
+		n, err := o.NewJSONString(test.have)
+		if n == nil || n.String() != test.want {
+			t.Errorf("%s: got %v, want %s", test.have, n, test.want)
+		}
+		if test.errs == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", test.have, err)
+			}
+			continue
+		}
+		errs, ok := err.(airp.ParseErrors)
+		if !ok || len(errs) != len(test.errs) || !errors.Is(err, test.kind) {
+			t.Errorf("%s: got %v, want %d errors of %v", test.have, err, len(test.errs), test.kind)
+			continue
+		}
+		for i, e := range errs {
+			if row, col := e.Where(); row != test.errs[i].Line || col != test.errs[i].Column {
+				t.Errorf("%s: got error %v, want at %d:%d", test.have, e, test.errs[i].Line, test.errs[i].Column)
+			}
+		}
+	}
+
+	// a comment after a dropped member waits for the next one
+	n, _ := airp.ParseOptions{Tolerant: true, Syntax: airp.SyntaxJSONC}.NewJSONString("{\"a\": 1, x: 2, // t\n\"b\":3}")
+	a, _ := n.GetChild("a")
+	b, _ := n.GetChild("b")
+	if a.Comments(airp.TrailingComment) != nil || !reflect.DeepEqual(b.Comments(airp.LeadingComment), []string{"// t"}) {
+		t.Errorf("got comments %q of a, %q of b", a.Comments(airp.TrailingComment), b.Comments(airp.LeadingComment))
+	}
+}
+
+func TestTolerantErrors(t *testing.T) {
+	_, err := airp.ParseOptions{Tolerant: true}.NewJSONString(`[1, {"a": 1, 2: 3, "b" 4}]`)
+	errs, ok := err.(airp.ParseErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got %v", err)
+	}
+	if s := errs[0].Error(); !strings.HasSuffix(s, "(at 1 in Object)") {
+		t.Errorf("key of missing key: got %s", s)
+	}
+	if s := errs[1].Error(); !strings.HasSuffix(s, "(at 1.b in Object)") {
+		t.Errorf("key of member: got %s", s)
+	}
+
+	s := "[" + strings.Repeat("nul, ", 500) + "1]"
+	for _, max := range []int{0, 3, 1000} {
+		n, err := airp.ParseOptions{Tolerant: true, MaxErrors: max}.NewJSONString(s)
+		want := max
+		if max == 0 {
+			want = 100
+		} else if max > 500 {
+			want = 500
+		}
+		if errs, ok := err.(airp.ParseErrors); !ok || len(errs) != want || n == nil {
+			t.Errorf("MaxErrors %d: got %d errors, want %d", max, len(errs), want)
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	fix := func(k airp.FixKind, col int) airp.Fix {
+		return airp.Fix{Kind: k, Pos: airp.Position{Line: 0, Column: col, Offset: col}}
+	}
+	tests := []struct {
+		have  string
+		want  string
+		fixes []airp.Fix
+	}{
+		{`{"a": [1, "x`, `{"a":[1,"x"]}`, []airp.Fix{
+			fix(airp.FixCloseString, 10), fix(airp.FixCloseArray, 12), fix(airp.FixCloseObject, 12)}},
+		{`[1,]`, `[1]`, []airp.Fix{fix(airp.FixDropComma, 2)}},
+		{`["a",`, `["a"]`, []airp.Fix{fix(airp.FixDropComma, 4), fix(airp.FixCloseArray, 5)}},
+		{`{a: 1, 'b': 'c'}`, `{"a":1,"b":"c"}`, []airp.Fix{
+			fix(airp.FixQuoteKey, 1), fix(airp.FixSingleQuotes, 7), fix(airp.FixSingleQuotes, 12)}},
+		{`{1: true}`, `{"1":true}`, []airp.Fix{fix(airp.FixQuoteKey, 1)}},
+		{`{"a":1,`, `{"a":1}`, []airp.Fix{fix(airp.FixDropComma, 6), fix(airp.FixCloseObject, 7)}},
+		{`{"a":`, `{}`, []airp.Fix{fix(airp.FixDropMember, 5), fix(airp.FixCloseObject, 5)}},
+		{`"ab\u12`, `"ab"`, []airp.Fix{fix(airp.FixCloseString, 0)}},
+		{`[true, null]`, `[true,null]`, nil},
+		{`{"a": tru`, `{"a":true}`, []airp.Fix{fix(airp.FixCompleteLiteral, 6), fix(airp.FixCloseObject, 9)}},
+		{`[n`, `[null]`, []airp.Fix{fix(airp.FixCompleteLiteral, 1), fix(airp.FixCloseArray, 2)}},
+		{`{"a": -`, `{}`, []airp.Fix{fix(airp.FixDropMember, 7), fix(airp.FixCloseObject, 7)}},
+		{`[1, 1.5e`, `[1,1.5]`, []airp.Fix{fix(airp.FixCompleteNumber, 4), fix(airp.FixCloseArray, 8)}},
+		{`[1,2}`, `[1,2]`, []airp.Fix{fix(airp.FixReplaceBracket, 4)}},
+		{`[1,}`, `[1]`, []airp.Fix{fix(airp.FixDropComma, 2), fix(airp.FixReplaceBracket, 3)}},
+		{`[{"a":1]`, `[{"a":1}]`, []airp.Fix{fix(airp.FixCloseObject, 7)}},
+		{`{"a":[1}`, `{"a":[1]}`, []airp.Fix{fix(airp.FixCloseArray, 7)}},
+		{`{"a":1,]`, `{"a":1}`, []airp.Fix{fix(airp.FixDropComma, 6), fix(airp.FixReplaceBracket, 7)}},
+		{`{'it\'s': 1}`, `{"it's":1}`, []airp.Fix{fix(airp.FixSingleQuotes, 1)}},
+	}
+	for _, test := range tests {
+		n, fixes, err := airp.Repair([]byte(test.have))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.have, err)
+			continue
+		}
+		if n.String() != test.want {
+			t.Errorf("%s: got %v, want %s", test.have, n, test.want)
+		}
+		if !reflect.DeepEqual(fixes, test.fixes) {
+			t.Errorf("%s: got fixes %v, want %v", test.have, fixes, test.fixes)
+		}
+	}
+	// JSON5 that is not a fix
+	for _, have := range []string{`[1 2]`, ``, `{"a" 1}`, `["\x41"]`, `[+1]`, `[0x1]`, `[Infinity]`, `[1] // c`} {
+		if n, _, err := airp.Repair([]byte(have)); !errors.As(err, new(*airp.ParseError)) {
+			t.Errorf("%s: got %v, %v, want error", have, n, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	long := strings.Repeat("1,", 5000)
+	tests := []struct {
+		have   string
+		limits airp.Limits
+		kind   error
+		offset int
+	}{
+		{`[[[1]]]`, airp.Limits{MaxDepth: 2}, airp.ErrDepthExceeded, 2},
+		{`[1,2,345]`, airp.Limits{MaxBytes: 7}, airp.ErrInputTooLarge, 7},
+		{"[" + long + "1]", airp.Limits{MaxBytes: 10000}, airp.ErrInputTooLarge, 10000},
+		{`["ab", "abcd"]`, airp.Limits{MaxStringLength: 3}, airp.ErrStringTooLong, 11},
+		{`["ab\n"]`, airp.Limits{MaxStringLength: 3}, airp.ErrStringTooLong, 4},
+		{`[1, -1.5e10]`, airp.Limits{MaxNumberLength: 4}, airp.ErrNumberTooLong, 8},
+		{`{"a":1,"b":{},"c":3}`, airp.Limits{MaxMembers: 2}, airp.ErrTooManyMembers, 14},
+		{`[1,[2,3]]`, airp.Limits{MaxNodes: 3}, airp.ErrTooManyNodes, 4},
+		{`{"ab":["cd",12]}`, airp.Limits{MaxDepth: 2, MaxBytes: 16, MaxStringLength: 2,
+			MaxNumberLength: 2, MaxMembers: 1, MaxNodes: 4}, nil, 0},
+	}
+	for _, test := range tests {
+		for _, o := range []airp.ParseOptions{{Limits: test.limits}, {Limits: test.limits, Tolerant: true}} {
+			_, err := o.NewJSONReader(strings.NewReader(test.have))
+			if test.kind == nil {
+				if err != nil {
+					t.Errorf("%s: unexpected error %v", test.have, err)
+				}
+				continue
+			}
+			if errs, ok := err.(airp.ParseErrors); ok && o.Tolerant {
+				err = errs[len(errs)-1]
+			}
+			pErr, ok := err.(*airp.ParseError)
+			if !ok || !errors.Is(err, test.kind) {
+				t.Errorf("%s: got %v, want %v", test.have, err, test.kind)
+			} else if pErr.Offset() != test.offset {
+				t.Errorf("%s: got offset %d, want %d", test.have, pErr.Offset(), test.offset)
+			}
+		}
+
+		if test.kind == airp.ErrTooManyMembers || test.kind == airp.ErrTooManyNodes {
+			continue // not applied by the decoder
+		}
+		d := airp.ParseOptions{Limits: test.limits}.NewDecoder(strings.NewReader(test.have))
+		var err error
+		for err == nil {
+			_, err = d.Token()
+		}
+		if test.kind == nil {
+			test.kind = io.EOF
+		}
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: decoder got %v, want %v", test.have, err, test.kind)
+		}
+	}
+
+	// the key of limit errors is cut to its end
+	deep := strings.Repeat(`{"a":`, 101)
+	want := `0:500: maximum depth exceeded '{' (at ...a.a.a in Object)`
+	if _, err := (airp.ParseOptions{Limits: airp.Limits{MaxDepth: 100}}).NewJSONString(deep); err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+	d := airp.ParseOptions{Limits: airp.Limits{MaxDepth: 100}}.NewDecoder(strings.NewReader(deep))
+	var err error
+	for err == nil {
+		_, err = d.Token()
+	}
+	if err.Error() != want {
+		t.Errorf("decoder got %v, want %s", err, want)
+	}
+}
+
+// readerFunc is an io.Reader calling itself.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestContext(t *testing.T) {
+	n, err := airp.NewJSONReaderContext(context.Background(), strings.NewReader(`{"a":[1,2]}`))
+	if err != nil || n.String() != `{"a":[1,2]}` {
+		t.Errorf("got %v, %v", n, err)
+	}
+
+	// a context that is never done reads directly
+	before := runtime.NumGoroutine()
+	var during int
+	r0 := readerFunc(func(p []byte) (int, error) {
+		during = runtime.NumGoroutine()
+		return 0, io.EOF
+	})
+	airp.NewJSONReaderContext(context.Background(), io.NopCloser(r0))
+	if during > before {
+		t.Errorf("read in a new goroutine")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := airp.NewJSONReaderContext(ctx, strings.NewReader(`[1]`)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	// the pipes block until the deadline
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte(`{"a": [1, `))
+	if _, err := airp.NewJSONReaderContext(ctx, r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := w.Write([]byte(`2]}`)); err != io.ErrClosedPipe {
+		t.Errorf("got %v, want reader closed", err)
+	}
+	o := airp.ParseOptions{Lossless: true}
+	if _, err := o.NewJSONReaderContext(ctx, r); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lossless got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	r, w = io.Pipe()
+	defer r.Close()
+	if _, err := n.EncodeContext(ctx, w, airp.EncodeOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := airp.NewEncoder(w, airp.EncodeOptions{}).EncodeContext(ctx, n); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("encoder got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// connections are stopped with a deadline
+	c, peer := net.Pipe()
+	defer c.Close()
+	defer peer.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := airp.NewJSONReaderContext(ctx, c); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("conn got %v, want %v", err, context.DeadlineExceeded)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := n.EncodeContext(ctx, c, airp.EncodeOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("conn got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want deadline of conn set", err)
+	}
+}
+
+func TestPushParser(t *testing.T) {
+	var got []string
+	p := airp.NewPushParser(func(n *airp.Node) { got = append(got, n.String()) })
+	chunks := []struct {
+		chunk string
+		want  int // number of values complete after chunk
+	}{
+		{"{\"a\": \"h\xc3", 0},
+		{"\xb6llo\\u00", 0},
+		{`e4", "b": 12`, 0},
+		{`34}  [tr`, 1},
+		{`ue] 5`, 2},
+		{`6 `, 3},
+		{`"x"`, 4},
+	}
+	for _, c := range chunks {
+		if _, err := p.Write([]byte(c.chunk)); err != nil {
+			t.Fatalf("%s: %v", c.chunk, err)
+		}
+		if len(got) != c.want {
+			t.Errorf("%s: got %d values, want %d", c.chunk, len(got), c.want)
+		}
+	}
+	n, err := p.Close()
+	want := []string{`{"a":"hölloä","b":1234}`, `[true]`, `56`, `"x"`}
+	if err != nil || n.String() != `"x"` || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, %v, %q", n, err, got)
+	}
+
+	// every byte a chunk
+	input := `{"key": [1.5e3, null, "a\"b"], "c": {}}`
+	p = airp.NewPushParser(nil)
+	for i := range input {
+		p.Write([]byte{input[i]})
+	}
+	if n, err := p.Close(); err != nil || n.String() != `{"key":[1.5e3,null,"a\"b"],"c":{}}` {
+		t.Errorf("got %v, %v", n, err)
+	}
+
+	// comments and escapes cut anywhere
+	input = "[\"\\ud83d\\ude00\", // c\n 1e5, \"\\\"ä\" /* d */]"
+	o := airp.ParseOptions{Syntax: airp.SyntaxJSONC}
+	want2, _ := o.NewJSONString(input)
+	for size := 1; size < 8; size++ {
+		p = o.NewPushParser(nil)
+		before := runtime.NumGoroutine()
+		for i := 0; i < len(input); i += size {
+			end := i + size
+			if end > len(input) {
+				end = len(input)
+			}
+			p.Write([]byte(input[i:end]))
+		}
+		if runtime.NumGoroutine() > before {
+			t.Errorf("%d: goroutine started", size)
+		}
+		n, err := p.Close()
+		var got, want bytes.Buffer
+		if n != nil {
+			n.Encode(&got, airp.EncodeOptions{})
+		}
+		want2.Encode(&want, airp.EncodeOptions{})
+		if err != nil || got.String() != want.String() {
+			t.Errorf("%d: got %s, %v, want %s", size, &got, err, &want)
+		}
+	}
+
+	p = airp.NewPushParser(nil)
+	p.Write([]byte(`[1,`))
+	_, err = p.Write([]byte(`2 x]`))
+	var pErr *airp.ParseError
+	if !errors.As(err, &pErr) || pErr.Offset() != 5 {
+		t.Errorf("got %v, want error at 5", err)
+	}
+	if _, err2 := p.Write([]byte(`3`)); err2 != err {
+		t.Errorf("got %v after error, want %v", err2, err)
+	}
+	if _, err2 := p.Close(); err2 != err {
+		t.Errorf("got %v on close, want %v", err2, err)
+	}
+
+	for _, have := range []string{`{"a"`, `"abc`, ``, ` `} {
+		p = airp.NewPushParser(nil)
+		p.Write([]byte(have))
+		if _, err := p.Close(); !errors.Is(err, airp.ErrUnexpectedEOF) {
+			t.Errorf("%q: got %v, want %v", have, err, airp.ErrUnexpectedEOF)
+		}
+	}
+}
+
+func TestStreamDecoder(t *testing.T) {
+	tests := []struct {
+		have    string
+		framing airp.Framing
+		want    []string // values or record:line of errors
+	}{
+		{"{\"a\":1}\n{\"a\":2}\n", airp.FramingLines, []string{`{"a":1}`, `{"a":2}`}},
+		{"{\"a\":1}\r\n\n{\"a\":2 x}\n[3]\n{\"b\"\n 4", airp.FramingLines,
+			[]string{`{"a":1}`, "1:2", `[3]`, "3:4", `4`}},
+		{"[1] [2]\n", airp.FramingLines, []string{"0:0"}},
+		{"{\"a\":1}{\"a\":2} 3 4\n{\"a\" x} [1]\n[2\n{\"z\":1}\n\"\\q\" 7\n5", airp.FramingConcat,
+			[]string{`{"a":1}`, `{"a":2}`, `3`, `4`, "4:1", "5:2", `{"z":1}`, "7:4", `5`}},
+		{"\x1e{\"a\":1}\n\x1e{\"a\":\n\x1e[2]\n\x1e\x1e 3\n", airp.FramingRecords,
+			[]string{`{"a":1}`, "1:1", `[2]`, `3`}},
+		{"", airp.FramingConcat, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		d := airp.NewStreamDecoder(strings.NewReader(test.have), test.framing)
+		for {
+			n, err := d.Next()
+			var rErr *airp.RecordError
+			if errors.As(err, &rErr) {
+				got = append(got, fmt.Sprintf("%d:%d", rErr.Record, rErr.Line))
+				continue
+			} else if err != nil {
+				if err != io.EOF {
+					t.Errorf("%q: unexpected error %v", test.have, err)
+				}
+				break
+			}
+			got = append(got, n.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.have, got, test.want)
+		}
+	}
+
+	// positions count from the start of the stream
+	d := airp.NewStreamDecoder(strings.NewReader("[1]\n[2, x]"), airp.FramingLines)
+	d.Next()
+	_, err := d.Next()
+	var pErr *airp.ParseError
+	if !errors.As(err, &pErr) || pErr.Offset() != 8 {
+		t.Errorf("got %v, want error at offset 8", err)
+	} else if row, col := pErr.Where(); row != 1 || col != 4 {
+		t.Errorf("got %v, want error at 1:4", err)
+	}
+
+	// errors of the reader end the stream
+	errRead := errors.New("read failed")
+	d = airp.NewStreamDecoder(io.MultiReader(strings.NewReader("[1]\n[2"), iotest.ErrReader(errRead)), airp.FramingLines)
+	if n, err := d.Next(); err != nil || n.String() != "[1]" {
+		t.Errorf("got %v, %v", n, err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := d.Next(); err != errRead {
+			t.Errorf("got %v, want %v", err, errRead)
+		}
+	}
+}
+
+func TestStreamEncoder(t *testing.T) {
+	b := &bytes.Buffer{}
+	e := airp.NewStreamEncoder(b)
+	n, _ := airp.ParseOptions{Syntax: airp.SyntaxJSONC}.NewJSONString("{\n  \"a\": 1, // one\n  \"b\": [true]\n}")
+	e.Encode(n)
+	e.Encode(airp.StandaloneNode("", `"x"`).Node)
+	if want := "{\"a\":1,\"b\":[true]}\n\"x\"\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var in strings.Builder
+	for i := 0; i < 100; i++ {
+		switch i {
+		case 10:
+			in.WriteString("{\"i\": x}\n")
+		default:
+			fmt.Fprintf(&in, "{\"i\": %d}\n", i)
+		}
+	}
+	double := func(n *airp.Node) (*airp.Node, error) {
+		m, _ := n.GetChild("i")
+		v, _ := m.Value()
+		i := int(v.(float64))
+		time.Sleep(time.Duration(i%7) * time.Millisecond)
+		switch i {
+		case 20:
+			return nil, errors.New("twenty")
+		case 30:
+			return nil, nil
+		}
+		return airp.NewJSONGo(map[string]int{"i": 2 * i})
+	}
+	var errs []string
+	b := &bytes.Buffer{}
+	p := airp.Pipeline{Workers: 4, OnError: func(err *airp.RecordError) error {
+		errs = append(errs, fmt.Sprintf("%d:%d", err.Record, err.Line))
+		return nil
+	}}
+	err := p.Run(airp.NewStreamEncoder(b), airp.NewStreamDecoder(strings.NewReader(in.String()), airp.FramingLines), double)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		if i != 10 && i != 20 && i != 30 {
+			fmt.Fprintf(&want, "{\"i\":%d}\n", 2*i)
+		}
+	}
+	if b.String() != want.String() {
+		t.Errorf("got\n%s", diff.LineDiff(b.String(), want.String()))
+	}
+	if !reflect.DeepEqual(errs, []string{"10:10", "20:20"}) {
+		t.Errorf("got errors %v", errs)
+	}
+
+	b.Reset()
+	err = airp.Pipeline{}.Run(airp.NewStreamEncoder(b), airp.NewStreamDecoder(strings.NewReader(in.String()), airp.FramingLines), double)
+	var rErr *airp.RecordError
+	if !errors.As(err, &rErr) || rErr.Record != 10 || strings.Count(b.String(), "\n") != 10 {
+		t.Errorf("got %v after %q", err, b.String())
+	}
+}
+
+func TestWalk(t *testing.T) {
+	var got []string
+	event := func(name string) func(string, airp.Token) error {
+		return func(path string, t airp.Token) error {
+			got = append(got, name+" "+path+" "+t.Value)
+			switch {
+			case t.Value == "skip", t.Value == "[" && path == "b.1":
+				return airp.SkipSubtree
+			case t.Value == "stop":
+				return errors.New("stop")
+			}
+			return nil
+		}
+	}
+	h := airp.Handler{
+		OnObjectStart: event("{"),
+		OnObjectEnd:   event("}"),
+		OnArrayStart:  event("["),
+		OnArrayEnd:    event("]"),
+		OnKey:         event("key"),
+		OnValue:       event("value"),
+	}
+	err := airp.Walk(strings.NewReader(`{"a": 1, "skip": {"x": [1]}, "b": [true, [null], {"c": "d"}]}`), h)
+	want := []string{
+		"{  {", "key a a", "value a 1", "key skip skip",
+		"key b b", "[ b [", "value b.0 true", "[ b.1 [",
+		"{ b.2 {", "key b.2.c c", "value b.2.c d", "} b.2 }",
+		"] b ]", "}  }",
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\n%q\nwant\n%q", err, got, want)
+	}
+
+	got = nil
+	err = airp.Walk(strings.NewReader(`[1, "stop", 3]`), airp.Handler{OnValue: event("value")})
+	if err == nil || err.Error() != "stop" || len(got) != 2 {
+		t.Errorf("got %v, %q", err, got)
+	}
+
+	err = airp.Walk(strings.NewReader(`[1, {"a" 2}]`), airp.Handler{})
+	if !errors.Is(err, airp.ErrUnexpectedToken) {
+		t.Errorf("got %v, want %v", err, airp.ErrUnexpectedToken)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	input := `{"data": {"count": 2, "items": [
+		{"id": 1, "tags": ["a"], "more": {"id": "no"}},
+		{"id": 2.5e1, "tags": [], "more": null}
+	]}, "id": "top"}`
+	tests := []struct {
+		paths []string
+		want  []string // path=value
+	}{
+		{[]string{"data.items.*.id"}, []string{"data.items.0.id=1", "data.items.1.id=2.5e1"}},
+		{[]string{"data.items.1"}, []string{`data.items.1={"id":2.5e1,"tags":[],"more":null}`}},
+		{[]string{"*.count", "id", "data.items.0.tags.*"}, []string{"data.count=2", `data.items.0.tags.0="a"`, `id="top"`}},
+		{[]string{"data.items", "data.items.*.id"}, []string{`data.items=[{"id":1,"tags":["a"],"more":{"id":"no"}},{"id":2.5e1,"tags":[],"more":null}]`}},
+		{[]string{"data.nothing", "data.items.2"}, nil},
+		{[]string{"*.*.*.tags"}, []string{`data.items.0.tags=["a"]`, `data.items.1.tags=[]`}},
+	}
+	for _, test := range tests {
+		var got []string
+		err := airp.Extract(strings.NewReader(input), test.paths, func(path string, n *airp.Node) error {
+			got = append(got, path+"="+n.String())
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, %q, want %q", test.paths, err, got, test.want)
+		}
+	}
+
+	full, _ := airp.NewJSONString(input)
+	airp.Extract(strings.NewReader(input), []string{""}, func(path string, n *airp.Node) error {
+		if !airp.EqNode(n, full) {
+			t.Errorf("got %v, want %v", n, full)
+		}
+		for _, key := range []string{"data.items.1.id", "data.items", "id"} {
+			m, _ := n.GetChild(key)
+			want, _ := full.GetChild(key)
+			s, ok := m.Span()
+			ws, _ := want.Span()
+			ks, _ := m.KeySpan()
+			wks, _ := want.KeySpan()
+			if !ok || s != ws || ks != wks {
+				t.Errorf("%s: got spans %v %v, want %v %v", key, s, ks, ws, wks)
+			}
+		}
+		return nil
+	})
+
+	stop := errors.New("stop")
+	calls := 0
+	err := airp.Extract(strings.NewReader(input), []string{"data.items.*"}, func(string, *airp.Node) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+
+	// skipped arrays and objects are only checked for brackets and strings
+	skips := []struct {
+		have string
+		opts airp.ParseOptions
+		kind error
+	}{
+		{`{"a": {"b": "]}\"", "c": [1, {"d": "\u00e4"}]}, "id": 1}`, airp.ParseOptions{}, nil},
+		{`{"a": [1, /* ] */ 2], "id": 1}`, airp.ParseOptions{Syntax: airp.SyntaxJSONC}, nil},
+		{`{"a": [1, {"b": 2]], "id": 1}`, airp.ParseOptions{}, airp.ErrUnexpectedToken},
+		{`{"a": [1, "]`, airp.ParseOptions{}, airp.ErrUnexpectedEOF},
+		{`{"a": [[[1]]], "id": 1}`, airp.ParseOptions{Limits: airp.Limits{MaxDepth: 3}}, airp.ErrDepthExceeded},
+	}
+	for _, test := range skips {
+		var got []string
+		err := test.opts.Extract(strings.NewReader(test.have), []string{"id"}, func(path string, n *airp.Node) error {
+			got = append(got, path+"="+n.String())
+			return nil
+		})
+		switch {
+		case test.kind == nil && (err != nil || !reflect.DeepEqual(got, []string{"id=1"})):
+			t.Errorf("%s: got %v, %q", test.have, err, got)
+		case test.kind != nil && !errors.Is(err, test.kind):
+			t.Errorf("%s: got %v, want %v", test.have, err, test.kind)
+		}
+	}
+}
+
+func TestArrayIterator(t *testing.T) {
+	tests := []struct {
+		have  string
+		want  []string
+		index int // of the element with an error; -1 for other errors
+		kind  error
+	}{
+		{` [{"a": 1}, [2, []], "x", null]`, []string{`{"a":1}`, `[2,[]]`, `"x"`, `null`}, 0, nil},
+		{`[]`, nil, 0, nil},
+		{`[1, {"a" 2}, 3]`, []string{`1`}, 1, airp.ErrUnexpectedToken},
+		{`[1, 2`, []string{`1`, `2`}, 2, airp.ErrUnexpectedEOF},
+		{`{"a": [1]}`, nil, -1, airp.ErrUnexpectedToken},
+		{`[1] 2`, []string{`1`}, -1, airp.ErrUnexpectedToken},
+		{``, nil, -1, airp.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		var got []string
+		it := airp.NewArrayIterator(strings.NewReader(test.have))
+		n, err := it.Next()
+		for ; err == nil; n, err = it.Next() {
+			got = append(got, n.String())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.have, got, test.want)
+		}
+		if _, err2 := it.Next(); err2 != err {
+			t.Errorf("%s: got %v after %v", test.have, err2, err)
+		}
+		var eErr *airp.ElementError
+		switch {
+		case test.kind == nil:
+			if err != io.EOF {
+				t.Errorf("%s: unexpected error %v", test.have, err)
+			}
+		case !errors.Is(err, test.kind):
+			t.Errorf("%s: got %v, want %v", test.have, err, test.kind)
+		case errors.As(err, &eErr) != (test.index >= 0):
+			t.Errorf("%s: got %v, want element error %t", test.have, err, test.index >= 0)
+		case eErr != nil && eErr.Index != test.index:
+			t.Errorf("%s: got index %d, want %d", test.have, eErr.Index, test.index)
+		}
+	}
+
+	// elements are read as they arrive
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte(`[{"i": 0}`))
+		for i := 1; i < 1000; i++ {
+			fmt.Fprintf(w, `, {"i": %d}`, i)
+		}
+		w.Write([]byte(`]`))
+		w.Close()
+	}()
+	it := airp.NewArrayIterator(r)
+	i := 0
+	for n, err := it.Next(); err != io.EOF; n, err = it.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf(`{"i":%d}`, i); n.String() != want || n.Key() != "" {
+			t.Errorf("got %v, want %s", n, want)
+		}
+		i++
+	}
+	if i != 1000 {
+		t.Errorf("got %d elements, want 1000", i)
+	}
+}
@@ -2,10 +2,11 @@ package airp
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"strings"
 	"testing"
-	"time"
+	"testing/iotest"
 )
 
 func TestLexer(t *testing.T) {
@@ -31,7 +32,7 @@ func TestLexer(t *testing.T) {
 			{Type: commaToken, position: [2]int{0, 13}},
 			{Type: numberToken, value: "5", position: [2]int{0, 15}},
 			{Type: commaToken, position: [2]int{0, 16}},
-			{Type: stringToken, value: "ab\\\"cd", position: [2]int{0, 18}},
+			{Type: stringToken, value: "ab\"cd", position: [2]int{0, 18}},
 			{Type: arrayCToken, position: [2]int{0, 26}},
 		},
 	}, {
@@ -107,16 +108,16 @@ func TestLexer(t *testing.T) {
 	}}
 outer:
 	for _, test := range tests {
-		lexc, q := lex(strings.NewReader(test.have))
+		l := lex(strings.NewReader(test.have))
 		for _, w := range test.want {
-			tk := <-lexc
+			tk, _ := l.next()
+			tk.span = [2]Position{} // checked by TestTokenSpan
 			if tk != w {
 				t.Errorf("have %v, got %s, want %s", test.have, tk, w)
-				q()
 				continue outer
 			}
 		}
-		if tk, ok := <-lexc; ok {
+		if tk, ok := l.next(); ok {
 			t.Errorf("expected nothing, got %s", tk.String())
 		}
 	}
@@ -126,39 +127,67 @@ func TestLexeErr(t *testing.T) {
 	tests := []struct {
 		have string
 		want token
+		kind error
 	}{{
 		`{"a": nul}`,
 		token{
 			value:    "nul",
 			position: [2]int{0, 6},
 		},
+		ErrUnexpectedToken,
 	}, {
 		`{"a": "\"}`,
 		token{
 			value:    `"\"}`,
 			position: [2]int{0, 6},
 		},
+		ErrUnexpectedEOF,
 	}, {
 		`{"a". false}`,
 		token{
 			value:    ".",
 			position: [2]int{0, 4},
 		},
+		ErrUnexpectedToken,
 	}, {
 		"{\"a\"\n <garbage>}",
 		token{
 			value:    "<garbage>",
 			position: [2]int{1, 1},
 		},
+		ErrUnexpectedToken,
+	}, {
+		`["a\qb"]`,
+		token{
+			value:    `\q`,
+			position: [2]int{0, 3},
+		},
+		ErrInvalidEscape,
+	}, {
+		"[\n \"äö\\u00g0\"]",
+		token{
+			value:    `\u00g`,
+			position: [2]int{1, 4},
+		},
+		ErrInvalidEscape,
+	}, {
+		`"abc\`,
+		token{
+			value:    `\`,
+			position: [2]int{0, 4},
+		},
+		ErrUnexpectedEOF,
 	}}
 	for _, test := range tests {
 		var have token
-		lexc, _ := lex(strings.NewReader(test.have))
-		for tk := range lexc {
-			have = tk
+		var kind error
+		l := lex(strings.NewReader(test.have))
+		for tk, ok := l.next(); ok; tk, ok = l.next() {
+			have, kind = tk, l.err
 		}
-		if have != test.want {
-			t.Errorf("got %v, want %v, for %v", have.Error(), test.want, test.have)
+		have.span = [2]Position{}
+		if have != test.want || kind != test.kind {
+			t.Errorf("got %v (%v), want %v (%v), for %v", have.Error(), kind, test.want, test.kind, test.have)
 		}
 	}
 }
@@ -183,7 +212,7 @@ func TestParser(t *testing.T) {
 				{jsonType: Bool, value: false},
 				{jsonType: Number, value: -31.2},
 				{jsonType: Number, value: 5.},
-				{jsonType: String, value: "ab\\\"cd"},
+				{jsonType: String, value: "ab\"cd"},
 			},
 		},
 	}, {
@@ -233,10 +262,11 @@ func TestParseErr(t *testing.T) {
 		want ParseError
 	}{{
 		"",
-		ParseError{msg: "value"},
+		ParseError{kind: ErrUnexpectedEOF, msg: "value"},
 	}, {
 		"null 5",
 		ParseError{
+			kind:   ErrUnexpectedToken,
 			msg:    "delimiter",
 			token:  token{Type: numberToken, value: "5", position: [2]int{0, 5}},
 			before: token{Type: nullToken, position: [2]int{0, 0}},
@@ -244,6 +274,7 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`{"a": nul}`,
 		ParseError{
+			kind:       ErrUnexpectedToken,
 			msg:        "value",
 			token:      token{value: "nul", position: [2]int{0, 6}},
 			before:     token{Type: colonToken, position: [2]int{0, 4}},
@@ -253,6 +284,7 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`{"a": null`,
 		ParseError{
+			kind:       ErrUnexpectedEOF,
 			msg:        "delimiter",
 			token:      token{Type: nullToken, position: [2]int{0, 6}},
 			before:     token{Type: nullToken, position: [2]int{0, 6}},
@@ -262,6 +294,7 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`{"b": "\"}`,
 		ParseError{
+			kind:       ErrUnexpectedEOF,
 			msg:        "value",
 			token:      token{value: `"\"}`, position: [2]int{0, 6}},
 			before:     token{Type: colonToken, position: [2]int{0, 4}},
@@ -271,6 +304,7 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`{"a":[],"b":{"a". false}}`,
 		ParseError{
+			kind:       ErrUnexpectedToken,
 			msg:        "colon",
 			token:      token{value: ".", position: [2]int{0, 16}},
 			before:     token{Type: stringToken, value: "a", position: [2]int{0, 13}},
@@ -280,6 +314,7 @@ func TestParseErr(t *testing.T) {
 	}, {
 		"{\"very_long\"\n <garbage>}",
 		ParseError{
+			kind:       ErrUnexpectedToken,
 			msg:        "colon",
 			token:      token{value: "<garbage>", position: [2]int{1, 1}},
 			before:     token{Type: stringToken, value: "very_long", position: [2]int{0, 1}},
@@ -289,13 +324,16 @@ func TestParseErr(t *testing.T) {
 	}, {
 		"{",
 		ParseError{
+			kind:       ErrUnexpectedEOF,
 			msg:        "key",
+			token:      token{position: [2]int{0, 1}},
 			before:     token{Type: objectOToken, position: [2]int{0, 0}},
 			parentType: Object,
 		},
 	}, {
 		`[{"b":}]`,
 		ParseError{
+			kind:       ErrUnexpectedToken,
 			msg:        "value",
 			token:      token{Type: objectCToken, position: [2]int{0, 6}},
 			before:     token{Type: colonToken, position: [2]int{0, 5}},
@@ -305,6 +343,7 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`[{"b":true},false,5.2,]`,
 		ParseError{
+			kind:       ErrUnexpectedToken,
 			msg:        "value",
 			token:      token{Type: arrayCToken, position: [2]int{0, 22}},
 			before:     token{Type: commaToken, position: [2]int{0, 21}},
@@ -314,12 +353,14 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`abcdefghij`,
 		ParseError{
+			kind:  ErrUnexpectedToken,
 			msg:   "value",
 			token: token{value: "abcdefghij", position: [2]int{0, 0}},
 		},
 	}, {
 		`{"index":[{"inner":[null,true]}}]`,
 		ParseError{
+			kind:       ErrUnexpectedToken,
 			msg:        "array closing",
 			token:      token{Type: objectCToken, position: [2]int{0, 31}},
 			before:     token{Type: objectCToken, position: [2]int{0, 30}},
@@ -329,7 +370,8 @@ func TestParseErr(t *testing.T) {
 	}, {
 		`{"a":null,"a":true}`,
 		ParseError{
-			msg:        "unique key",
+			kind:       ErrDuplicateKey,
+			msg:        "",
 			parentType: Object,
 			token:      token{Type: stringToken, value: "a", position: [2]int{0, 10}},
 			before:     token{Type: commaToken, position: [2]int{0, 9}},
@@ -341,6 +383,8 @@ func TestParseErr(t *testing.T) {
 		if !ok {
 			t.Fatalf("error is not of type parse error in test: %T", err)
 		}
+		pErr.token.span, pErr.before.span = [2]Position{}, [2]Position{}
+		pErr.line, pErr.col = "", 0 // checked by TestParseErrSnippet
 		if *pErr != test.want {
 			t.Errorf("got %v, want %s, for %v", pErr, test.want.Error(), test.have)
 		}
@@ -535,7 +579,7 @@ func TestASTStringer(t *testing.T) {
 				{jsonType: Bool, value: false},
 				{jsonType: Number, value: -31.2},
 				{jsonType: Number, value: float64(5)},
-				{jsonType: String, value: "ab\\\"cd"},
+				{jsonType: String, value: "ab\"cd"},
 			},
 		},
 	}, {
@@ -579,7 +623,7 @@ func TestASTStringerDebug(t *testing.T) {
 				{jsonType: Bool, value: false},
 				{jsonType: Number, value: -31.2},
 				{jsonType: Number, value: float64(5)},
-				{jsonType: String, value: "ab\\\"cd"},
+				{jsonType: String, value: "ab\"cd"},
 			},
 		},
 	}, {
@@ -597,7 +641,7 @@ func TestASTStringerDebug(t *testing.T) {
 	}}
 	for _, test := range tests {
 		b := &strings.Builder{}
-		test.have.format(b, "!", "~", "-", "^")
+		test.have.format(b, formatOptions{indent: "!", newline: "~", commaSep: "-", colonSep: "^"})
 		got := b.String()
 		if got != test.want {
 			t.Errorf("want: %s, got: %s", test.want, got)
@@ -605,19 +649,123 @@ func TestASTStringerDebug(t *testing.T) {
 	}
 }
 
-func TestLexQuit(t *testing.T) {
-	lexc, q := lex(strings.NewReader(`["Hello, World!", 0, true]`))
-	if cap(lexc) != 1 {
-		t.Fatal("lex-channel must have capacity of 1")
+func TestLexEnd(t *testing.T) {
+	tests := []string{`["Hello, World!", 0, true]`, `[nul]`, `["open`}
+	for _, test := range tests {
+		for _, l := range []*lexer{lex(strings.NewReader(test)), lexBytes([]byte(test))} {
+			for _, ok := l.next(); ok; _, ok = l.next() {
+			}
+			if tk, ok := l.next(); ok {
+				t.Errorf("lexer not stopped after end of %s, got %s", test, tk)
+			}
+		}
+	}
+}
+
+func TestLexJSON5(t *testing.T) {
+	have := "{a /* x\ny */: 'b\\\nc', // z\n\t$1: -0x1,}"
+	want := []token{
+		{Type: objectOToken, position: [2]int{0, 0}},
+		{Type: identToken, value: "a", position: [2]int{0, 1}},
+		{Type: colonToken, position: [2]int{1, 4}},
+		{Type: stringToken, value: "bc", position: [2]int{1, 6}},
+		{Type: commaToken, position: [2]int{2, 2}},
+		{Type: identToken, value: "$1", position: [2]int{3, 1}},
+		{Type: colonToken, position: [2]int{3, 3}},
+		{Type: numberToken, value: "-0x1", position: [2]int{3, 5}},
+		{Type: commaToken, position: [2]int{3, 9}},
+		{Type: objectCToken, position: [2]int{3, 10}},
 	}
-	time.Sleep(time.Millisecond) // fill channel
-	q()                          // quit lexer
-	time.Sleep(time.Millisecond) // wait for quit
-	if len(lexc) != 1 {
-		t.Fatal("lex-channel must have length of 1")
+	l := lexBytes([]byte(have))
+	l.json5, l.comments = true, true
+	for _, w := range want {
+		if tk, _ := l.next(); tk.Type != w.Type || tk.value != w.value || tk.position != w.position {
+			t.Fatalf("got %s at %v, want %s at %v", tk, tk.position, w, w.position)
+		}
+	}
+	if tk, ok := l.next(); ok {
+		t.Errorf("expected nothing, got %s", tk.String())
+	}
+}
+
+func TestTokenSpan(t *testing.T) {
+	have := "[\"\\u00e4\", -1.5e3,\n\ttrue]"
+	want := [][2]int{{0, 1}, {1, 9}, {9, 10}, {11, 17}, {17, 18}, {20, 24}, {24, 25}}
+	for _, l := range []*lexer{lexBytes([]byte(have)), lex(iotest.OneByteReader(strings.NewReader(have)))} {
+		for _, w := range want {
+			if tk, _ := l.next(); tk.span[0].Offset != w[0] || tk.span[1].Offset != w[1] {
+				t.Errorf("%s: got span %v, want offsets %v", tk, tk.span, w)
+			}
+		}
+	}
+}
+
+func TestLexSmallReads(t *testing.T) {
+	input := `{"a": [1, "x\u00e4y", "äöü"], "b": null}`
+	want := lexBytes([]byte(input))
+	have := lex(iotest.OneByteReader(strings.NewReader(input)))
+	for {
+		wt, wok := want.next()
+		ht, hok := have.next()
+		if wt != ht || wok != hok {
+			t.Fatalf("got %s, want %s", ht, wt)
+		}
+		if !wok {
+			break
+		}
+	}
+}
+
+// chunkWriter records the size of each write and fails after limit bytes.
+type chunkWriter struct {
+	chunks   []int
+	total    int
+	limit    int
+	afterErr int
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.chunks = append(w.chunks, len(b))
+	if w.limit > 0 && w.total == w.limit {
+		w.afterErr++
+	}
+	if w.limit > 0 && w.total+len(b) > w.limit {
+		c := w.limit - w.total
+		w.total = w.limit
+		return c, errors.New("disk full")
+	}
+	w.total += len(b)
+	return len(b), nil
+}
+
+func TestFormatStreaming(t *testing.T) {
+	nn := make([]*Node, 10000)
+	for i := range nn {
+		nn[i] = &Node{jsonType: String, value: strings.Repeat("x", i%100)}
+	}
+	n := &Node{jsonType: Array, value: nn}
+	want := n.String()
+
+	w := &chunkWriter{}
+	c, err := n.format(w, formatOptions{})
+	if err != nil || c != len(want) || w.total != len(want) {
+		t.Fatalf("wrote %d (%d) of %d bytes, err: %v", c, w.total, len(want), err)
+	}
+	if min := len(want) / (formatBufSize + 128); len(w.chunks) < min {
+		t.Errorf("want at least %d writes, got %d", min, len(w.chunks))
+	}
+	for _, size := range w.chunks {
+		if size > formatBufSize+128 {
+			t.Errorf("write of %d bytes exceeds buffer size", size)
+		}
+	}
+
+	w = &chunkWriter{limit: 3 * formatBufSize}
+	c, err = n.WriteJSON(w)
+	if err == nil || err.Error() != "disk full" || c != w.limit {
+		t.Errorf("got %d bytes with err %v, want %d bytes and disk full", c, err, w.limit)
 	}
-	<-lexc // empty channel (length 1)
-	if _, ok := <-lexc; ok {
-		t.Error("lexer not stopped after receiving quit")
+	if w.afterErr != 0 {
+		t.Errorf("%d writes after an error", w.afterErr)
 	}
 }
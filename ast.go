@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -35,7 +37,7 @@ const (
 //     Error    nil
 //     Null     nil
 //     Bool     bool
-//     Number   float64
+//     Number   JSONNumber
 //     String   string
 //     Array    []*Node
 //     Object   []KeyNode
@@ -43,6 +45,10 @@ type Node struct {
 	jsonType JSONType
 	value    interface{}
 	parent   *Node
+	comments *comments
+	cst      *cst
+	span     Span
+	keySpan  Span
 }
 
 type KeyNode struct {
@@ -76,11 +82,21 @@ func EqNode(a, b *Node) bool {
 			return false
 		}
 		for i := range an {
-			if m, ok := b.GetChild(an[i].Key); !ok || !EqNode(an[i].Node, m) {
+			// repeated keys are matched by their order of occurrence
+			k := 0
+			for _, m := range an[:i] {
+				if m.Key == an[i].Key {
+					k++
+				}
+			}
+			m := nthKey(bn, an[i].Key, k)
+			if m == nil || !EqNode(an[i].Node, m) {
 				return false
 			}
 		}
 		return true
+	} else if a.jsonType == Number {
+		return numberEq(a.value, b.value)
 	} else if a.value == b.value {
 		return true
 	}
@@ -89,17 +105,17 @@ func EqNode(a, b *Node) bool {
 
 // NewJSON reads from b and generates an AST
 func NewJSON(b []byte) (*Node, error) {
-	return parse(lex(bytes.NewReader(b)))
+	return ParseOptions{}.NewJSON(b)
 }
 
 // NewJSONReader reads from r and generates an AST
 func NewJSONReader(r io.Reader) (*Node, error) {
-	return parse(lex(r))
+	return ParseOptions{}.NewJSONReader(r)
 }
 
 // NewJSONString reads from s and generates an AST
 func NewJSONString(s string) (*Node, error) {
-	return parse(lex(strings.NewReader(s)))
+	return ParseOptions{}.NewJSONString(s)
 }
 
 // NewJSONGo reads in a Go-value and generates a json ast that can be
@@ -113,11 +129,18 @@ func NewJSONGo(val interface{}) (*Node, error) {
 	case reflect.Bool:
 		return &Node{jsonType: Bool, value: v.Bool()}, nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return &Node{jsonType: Number, value: float64(v.Int())}, nil
+		return &Node{jsonType: Number,
+			value: JSONNumber(strconv.FormatInt(v.Int(), 10))}, nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return &Node{jsonType: Number, value: float64(v.Uint())}, nil
+		return &Node{jsonType: Number,
+			value: JSONNumber(strconv.FormatUint(v.Uint(), 10))}, nil
 	case reflect.Float32, reflect.Float64:
-		return &Node{jsonType: Number, value: v.Float()}, nil
+		f := v.Float()
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("unsupported value %v", f)
+		}
+		return &Node{jsonType: Number,
+			value: JSONNumber(strconv.FormatFloat(f, 'g', -1, v.Type().Bits()))}, nil
 	case reflect.String:
 		return &Node{jsonType: String, value: v.String()}, nil
 	case reflect.Slice:
@@ -282,6 +305,8 @@ func (n *Node) Value() (interface{}, error) {
 	switch n.jsonType {
 	default:
 		return n.value, nil
+	case Number:
+		return numberOf(n.value).Float64()
 	case Object:
 		m := make(map[string]interface{}, 2)
 		for _, f := range n.value.([]KeyNode) {
@@ -305,10 +330,20 @@ func (n *Node) Value() (interface{}, error) {
 	}
 }
 
-// String formats an ast as valid JSON with no whitspace.
+// Number returns the number held by a Number node as it was written in
+// JSON.
+func (n *Node) Number() (JSONNumber, error) {
+	if n.Type() != Number {
+		return "", fmt.Errorf("mismatched type: want Number got %s", n.Type())
+	}
+	return numberOf(n.value), nil
+}
+
+// String formats an ast as valid JSON with no whitspace. Comments are
+// left out.
 func (n *Node) String() string {
 	b := &strings.Builder{}
-	_, err := n.format(b, "", "", "", "")
+	_, err := n.format(b, formatOptions{stripComments: true})
 	if err != nil {
 		return ""
 	}
@@ -318,7 +353,7 @@ func (n *Node) String() string {
 // MarshalJSON implements the json.Mashaler interface for Node
 func (n *Node) MarshalJSON() ([]byte, error) {
 	b := &bytes.Buffer{}
-	_, err := n.format(b, "", "", " ", " ")
+	_, err := n.format(b, formatOptions{commaSep: " ", colonSep: " ", stripComments: true})
 	if err != nil {
 		return nil, err
 	}
@@ -336,15 +371,25 @@ func (n *Node) UnmarshalJSON(data []byte) error {
 }
 
 // WriteJSON writes the AST hold by n to w with the same representation as
-// n.String() and no whitspace.
+// n.String() and no whitspace. Comments are left out.
 func (n *Node) WriteJSON(w io.Writer) (int, error) {
-	return n.format(w, "", "", "", "")
+	return n.format(w, formatOptions{stripComments: true})
 }
 
 // WriteIndent writes the AST hold by n to w with the given indent
 // (preferably spaces or a tab).
 func (n *Node) WriteIndent(w io.Writer, indent string) (int, error) {
-	return n.format(w, indent, "\n", "", " ")
+	return n.format(w, formatOptions{indent: indent, newline: "\n", colonSep: " "})
+}
+
+// WriteEscaped writes the AST hold by n to w like WriteIndent but escapes
+// strings as mode says. An empty indent writes no whitspace like WriteJSON.
+func (n *Node) WriteEscaped(w io.Writer, indent string, mode EscapeMode) (int, error) {
+	return n.Encode(w, EncodeOptions{
+		Indent:          indent,
+		SpaceAfterColon: indent != "",
+		Escape:          mode,
+	})
 }
 
 // JSON2Go reads contents from n and writes them into val.
@@ -364,17 +409,20 @@ func (n *Node) AddChildren(nn ...KeyNode) {
 			}
 		}
 		n.value = append(n.value.([]KeyNode), nn...)
+		n.touch()
 	} else if n.jsonType == Array {
 		for _, m := range nn {
 			n.value = append(n.value.([]*Node), m.Node)
 		}
+		n.touch()
 	} else {
 		panic(errors.Wrapf(ErrNotArrayOrObject, "n is %s", n.jsonType))
 	}
 }
 
 // GetChild returns the node specifiend by name.
-// The key "" always returns the node itself.
+// The key "" always returns the node itself. If an object holds a key more
+// than once the last occurrence is used, the same as Value does.
 func (n *Node) GetChild(name string) (*Node, bool) {
 	keys := strings.Split(name, ".")
 	if len(keys) == 1 && keys[0] == "" {
@@ -383,7 +431,7 @@ func (n *Node) GetChild(name string) (*Node, bool) {
 	switch n.jsonType {
 	case Object:
 		kn := n.value.([]KeyNode)
-		for i := range kn {
+		for i := len(kn) - 1; i >= 0; i-- {
 			if kn[i].Key == keys[0] {
 				return kn[i].GetChild(strings.Join(keys[1:], "."))
 			}
@@ -414,6 +462,7 @@ func (n *Node) SetChild(kn KeyNode) error {
 	if ok {
 		m.jsonType = kn.Node.jsonType
 		m.value = kn.Node.value
+		m.touch()
 		return nil
 	}
 	/*
@@ -453,10 +502,11 @@ func (n *Node) RemoveChild(key string) error {
 	}
 	if n.jsonType == Object {
 		nn := n.value.([]KeyNode)
-		for i, m := range nn {
-			if keys[0] == m.Key {
+		for i := len(nn) - 1; i >= 0; i-- {
+			if m := nn[i]; keys[0] == m.Key {
 				m.parent = nil
 				n.value = append(nn[:i], nn[i+1:]...)
+				n.touch()
 				return nil
 			}
 		}
@@ -472,6 +522,7 @@ func (n *Node) RemoveChild(key string) error {
 		}
 		nn[i].parent = nil
 		n.value = append(nn[:i], nn[i+1:]...)
+		n.touch()
 		return nil
 	} else {
 		return errors.Wrapf(ErrNotArrayOrObject, "in %s", n.jsonType)
@@ -506,24 +557,29 @@ func (n *Node) GetChildrenKeys() []string {
 func (n *Node) Copy() *Node {
 	switch n.jsonType {
 	case Null, Bool, Number, String:
-		return &Node{jsonType: n.jsonType, value: n.value}
+		return &Node{jsonType: n.jsonType, value: n.value, span: n.span,
+			keySpan: n.keySpan, comments: n.comments.copy(), cst: n.cst.copy()}
 	case Array:
 		nn := n.value.([]*Node)
 		mm := make([]*Node, len(nn))
-		o := &Node{jsonType: Array, value: mm}
+		o := &Node{jsonType: Array, value: mm, span: n.span,
+			keySpan: n.keySpan, comments: n.comments.copy(), cst: n.cst.copy()}
 		for i, m := range nn {
 			mm[i] = m.Copy()
 			mm[i].parent = o
+			mm[i].cst.adopt(n.cst, o.cst)
 		}
 		return o
 	case Object:
 		kn := n.value.([]KeyNode)
 		mm := make([]KeyNode, len(kn))
-		o := &Node{jsonType: Object, value: mm}
+		o := &Node{jsonType: Object, value: mm, span: n.span,
+			keySpan: n.keySpan, comments: n.comments.copy(), cst: n.cst.copy()}
 		for i, m := range kn {
 			mm[i].Key = m.Key
 			mm[i].Node = m.Copy()
 			mm[i].parent = o
+			mm[i].cst.adopt(n.cst, o.cst)
 		}
 		return o
 	default:
@@ -576,115 +632,366 @@ func bytesRepeatBuf(buf []byte, rep string, num int) []byte {
 	return buf
 }
 
-// format writes a valid json representation to w with prefix as indent,
-// postfix after values or opening objects/arrays, colonSep after keys and
-// commaSep after each comma.
-func (n *Node) format(w io.Writer, prefix, postfix, commaSep, colonSep string) (int, error) {
+// formatOptions are the options of format. See EncodeOptions for the public
+// counterpart.
+type formatOptions struct {
+	indent   string // repeated for each level at the start of lines
+	newline  string // after values and opening objects/arrays
+	commaSep string // after each comma
+	colonSep string // after each key
+	esc      EscapeMode
+	sortKeys bool
+	numbers  NumberStyle
+	trailer  string // after the top-level value
+	width    int    // maximum line width for arrays and objects on one line
+
+	stripComments bool
+	preserve      bool // write unchanged nodes as in the input
+	json5         bool // write Infinity and NaN
+}
+
+// formatBufSize is the size at which format flushes its buffer.
+const formatBufSize = 4096
+
+// format writes a valid json representation to w with opts.indent as
+// indent, opts.newline after values or opening objects/arrays,
+// opts.colonSep after keys and opts.commaSep after each comma.
+// The output is written in chunks of about formatBufSize bytes. It returns
+// the number of bytes written and the first error.
+func (n *Node) format(w io.Writer, opts formatOptions) (int, error) {
 	if n == nil {
 		return 0, fmt.Errorf("<nil>")
 	}
 	var (
-		inner func(int) error
-		m, o  *Node = n, nil
-		buf         = make([]byte, 0, 1024)
+		inner   func(m *Node, o *formatOptions, level, col, tail int) error
+		buf     = make([]byte, 0, 1024)
+		written int
+		scratch []byte
+		// width of the indent and of the prefix of each line
+		indentWidth = utf8.RuneCountInString(opts.indent)
+		prefixWidth = utf8.RuneCountInString(opts.newline) - 1
+		// layout of arrays and objects written on a single line
+		flat = opts
 	)
-	inner = func(level int) error { // closure with single buffer
+	flat.indent, flat.newline, flat.commaSep, flat.width = "", "", " ", 0
+	flush := func() error {
+		c, err := w.Write(buf)
+		written += c
+		buf = buf[:0]
+		return err
+	}
+	// lineBreak ends a line comment c if o does not break lines.
+	lineBreak := func(c string, o *formatOptions) {
+		if o.newline == "" && isLineComment(c) {
+			buf = append(buf, '\n')
+		}
+	}
+	leading := func(m *Node, o *formatOptions, level int) {
+		if opts.stripComments {
+			return
+		}
+		for _, c := range m.Comments(LeadingComment) {
+			buf = append(buf, c...)
+			lineBreak(c, o)
+			buf = append(buf, o.newline...)
+			if o.newline != "" {
+				buf = bytesRepeatBuf(buf, o.indent, level)
+			}
+		}
+	}
+	trailing := func(m *Node, o *formatOptions) {
+		if opts.stripComments {
+			return
+		}
+		for _, c := range m.Comments(TrailingComment) {
+			buf = append(buf, ' ')
+			buf = append(buf, c...)
+			lineBreak(c, o)
+		}
+	}
+	innerComments := func(m *Node, o *formatOptions, level int) {
+		if opts.stripComments {
+			return
+		}
+		for _, c := range m.Comments(InnerComment) {
+			buf = append(buf, o.newline...)
+			buf = bytesRepeatBuf(buf, o.indent, level)
+			buf = append(buf, c...)
+			lineBreak(c, o)
+		}
+	}
+	// raw writes b which is part of the input.
+	raw := func(b []byte) error {
+		if len(b) < formatBufSize {
+			buf = append(buf, b...)
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		c, err := w.Write(b)
+		written += c
+		return err
+	}
+	// preserve writes m using its input. Arrays and objects that changed
+	// are written piece by piece keeping the input between unchanged
+	// children.
+	preserve := func(m *Node, o *formatOptions) error {
+		c := m.cst
+		if !c.dirty {
+			return raw(c.text(c.start, c.end))
+		}
+		var (
+			nn   []*Node
+			keys []string
+		)
+		switch v := m.value.(type) {
+		case []*Node:
+			nn = v
+		case []KeyNode:
+			nn, keys = make([]*Node, len(v)), make([]string, len(v))
+			for i, kn := range v {
+				nn[i], keys[i] = kn.Node, kn.Key
+			}
+		}
+		brackets := "[]"
+		if m.jsonType == Object {
+			brackets = "{}"
+		}
+		g, open, closing := c.layout(*o)
+		if c.child(nn[0], 0) {
+			if err := raw(c.text(c.start, nn[0].cst.keyStart)); err != nil {
+				return err
+			}
+		} else {
+			buf = append(buf, brackets[0])
+			buf = append(buf, open...)
+			leading(nn[0], &g, 0)
+		}
+		for i, k := range nn {
+			if i > 0 && c.adjacent(nn[i-1], k) {
+				err := raw(c.text(nn[i-1].cst.end, k.cst.keyStart))
+				if err != nil {
+					return err
+				}
+			} else if i > 0 {
+				buf = append(buf, ',')
+				trailing(nn[i-1], &g)
+				buf = append(buf, (g.commaSep + g.newline)...)
+				leading(k, &g, 0)
+			}
+			if keys != nil {
+				if k.cst != nil && k.cst.owner == c && k.cst.key == keys[i] {
+					buf = append(buf, c.text(k.cst.keyStart, k.cst.start)...)
+				} else {
+					buf = appendQuoted(buf, keys[i], opts.esc)
+					buf = append(buf, (":" + g.colonSep)...)
+				}
+			}
+			if err := inner(k, &g, 0, 0, 0); err != nil {
+				return err
+			}
+		}
+		last := nn[len(nn)-1]
+		if c.child(last, c.len-1) && !c.commentsChanged(InnerComment) {
+			return raw(c.text(last.cst.end, c.end))
+		}
+		trailing(last, &g)
+		innerComments(m, &g, 0)
+		buf = append(buf, closing...)
+		buf = append(buf, brackets[1])
+		return nil
+	}
+	// measure returns the room left after writing m on a single line.
+	// It is negative if m does not fit.
+	var measure func(m *Node, room int) int
+	measure = func(m *Node, room int) int {
 		if !isValid(m) {
-			return fmt.Errorf("format; assertion failure")
+			return -1 // reported by inner
+		}
+		if m.comments.any() && !opts.stripComments {
+			return -1 // comments are kept on their own lines
 		}
 		switch m.jsonType {
-		case Null:
-			buf = append(buf, "null"...)
-			return nil
-		case Bool:
-			if m.value.(bool) {
-				buf = append(buf, "true"...)
-				return nil
+		case Array:
+			cc := m.value.([]*Node)
+			room -= 2 // brackets
+			for i, c := range cc {
+				if room < 0 {
+					break
+				}
+				if i > 0 {
+					room -= 2 // comma and space
+				}
+				room = measure(c, room)
 			}
-			buf = append(buf, "false"...)
-			return nil
-		case Number:
-			buf = strconv.AppendFloat(buf, m.value.(float64), 'g', -1, 64)
-			return nil
-		case String:
-			buf = append(buf, (`"` + m.value.(string) + `"`)...)
-			return nil
+		case Object:
+			cc := m.value.([]KeyNode)
+			room -= 2 // brackets
+			for i, c := range cc {
+				if room < 0 {
+					break
+				}
+				if i > 0 {
+					room -= 2 // comma and space
+				}
+				scratch = appendQuoted(scratch[:0], c.Key, opts.esc)
+				room -= utf8.RuneCount(scratch) + 1 + len(opts.colonSep)
+				room = measure(c.Node, room)
+			}
+		default:
+			scratch = appendScalar(scratch[:0], m, &opts)
+			room -= utf8.RuneCount(scratch)
+		}
+		return room
+	}
+	// inner writes m with the layout of o starting at column col of a line.
+	// tail is the width of what follows m on its line.
+	inner = func(m *Node, o *formatOptions, level, col, tail int) error { // closure with single buffer
+		if len(buf) >= formatBufSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if !isValid(m) {
+			return fmt.Errorf("format; assertion failure")
+		}
+		if opts.preserve && m.preserved() {
+			return preserve(m, o)
+		}
+		if o.width > 0 && (m.jsonType == Array || m.jsonType == Object) &&
+			measure(m, o.width-col-tail) >= 0 {
+			o = &flat
+		}
+		lineWidth := prefixWidth + indentWidth*(level+1)
+		switch m.jsonType {
 		case Array:
 			cc := m.value.([]*Node)
-			if len(cc) == 0 {
-				buf = bytesRepeatBuf(buf, prefix, level)
+			if len(cc) == 0 && len(m.Comments(InnerComment)) == 0 {
 				buf = append(buf, "[]"...)
 				return nil
 			}
-			buf = append(buf, ("[" + postfix)...)
-			for _, c := range cc[:len(cc)-1] {
-				buf = bytesRepeatBuf(buf, prefix, level+1)
-				m, o = c, m
-				err := inner(level + 1)
+			buf = append(buf, '[')
+			for i, c := range cc {
+				if i > 0 {
+					buf = append(buf, ',')
+					trailing(cc[i-1], o)
+					buf = append(buf, o.commaSep...)
+				}
+				buf = append(buf, o.newline...)
+				buf = bytesRepeatBuf(buf, o.indent, level+1)
+				leading(c, o, level+1)
+				err := inner(c, o, level+1, lineWidth, commaWidth(i, len(cc)))
 				if err != nil {
 					return err
 				}
-				m = o
-				buf = append(buf, ("," + commaSep + postfix)...)
 			}
-			buf = bytesRepeatBuf(buf, prefix, level+1)
-			m, o = cc[len(cc)-1], m
-			err := inner(level + 1)
-			if err != nil {
-				return err
+			if len(cc) > 0 {
+				trailing(cc[len(cc)-1], o)
 			}
-			m = o
-			buf = append(buf, postfix...)
-			buf = bytesRepeatBuf(buf, prefix, level)
+			innerComments(m, o, level+1)
+			buf = append(buf, o.newline...)
+			buf = bytesRepeatBuf(buf, o.indent, level)
 			buf = append(buf, ']')
 			return nil
 		case Object:
 			cc := m.value.([]KeyNode)
-			if len(cc) == 0 {
-				buf = bytesRepeatBuf(buf, prefix, level)
+			if len(cc) == 0 && len(m.Comments(InnerComment)) == 0 {
 				buf = append(buf, "{}"...)
 				return nil
 			}
-			buf = append(buf, ("{" + postfix)...)
-			for _, c := range cc[:len(cc)-1] {
-
-				buf = bytesRepeatBuf(buf, prefix, level+1)
-				buf = append(buf, ("\"" + c.Key + "\":" + colonSep)...)
-
-				m, o = c.Node, m
-				err := inner(level + 1)
+			if o.sortKeys {
+				cc = sortedKeys(cc)
+			}
+			buf = append(buf, '{')
+			for i, c := range cc {
+				if i > 0 {
+					buf = append(buf, ',')
+					trailing(cc[i-1].Node, o)
+					buf = append(buf, o.commaSep...)
+				}
+				buf = append(buf, o.newline...)
+				buf = bytesRepeatBuf(buf, o.indent, level+1)
+				leading(c.Node, o, level+1)
+				start := len(buf)
+				buf = appendQuoted(buf, c.Key, opts.esc)
+				buf = append(buf, (":" + o.colonSep)...)
+				col := lineWidth
+				if o.width > 0 {
+					col += utf8.RuneCount(buf[start:])
+				}
+				err := inner(c.Node, o, level+1, col, commaWidth(i, len(cc)))
 				if err != nil {
 					return err
 				}
-				buf = append(buf, ("," + commaSep + postfix)...)
-				m = o
 			}
-
-			buf = bytesRepeatBuf(buf, prefix, level+1)
-			buf = append(buf, ("\"" + cc[len(cc)-1].Key + "\":" + colonSep)...)
-
-			m, o = cc[len(cc)-1].Node, m
-			err := inner(level + 1)
-			if err != nil {
-				return err
+			if len(cc) > 0 {
+				trailing(cc[len(cc)-1].Node, o)
 			}
-			m = o
-			buf = append(buf, postfix...)
-			buf = bytesRepeatBuf(buf, prefix, level)
-			buf = append(buf, "}"...)
+			innerComments(m, o, level+1)
+			buf = append(buf, o.newline...)
+			buf = bytesRepeatBuf(buf, o.indent, level)
+			buf = append(buf, '}')
 			return nil
-		case Error:
-			buf = append(buf, "<error>"...)
+		case Null, Bool, Number, String, Error:
+			if m.jsonType == Number && !opts.json5 && !isFinite(m.value) {
+				return fmt.Errorf("number %s is not valid JSON", appendNumber(nil, m.value, opts.numbers))
+			}
+			buf = appendScalar(buf, m, &opts)
 			return nil
 		default:
 			return fmt.Errorf("node of unknown type: %#v", m)
 		}
 	}
-	err := inner(0)
+	// the input around a parsed top-level value is kept with its comments
+	root := opts.preserve && n.cst != nil && n.cst.owner == nil && n.parent == nil
+	if root && !n.cst.commentsChanged(LeadingComment) {
+		buf = append(buf, n.cst.text(0, n.cst.keyStart)...)
+	} else {
+		leading(n, &opts, 0)
+	}
+	err := inner(n, &opts, 0, 0, 0)
 	if err != nil {
-		return 0, err
+		return written, err
 	}
-	return w.Write(buf)
+	if root && !n.cst.commentsChanged(TrailingComment) {
+		err = raw(n.cst.text(n.cst.end, len(n.cst.src)))
+		if err != nil {
+			return written, err
+		}
+	} else {
+		trailing(n, &opts)
+		buf = append(buf, opts.trailer...)
+	}
+	err = flush()
+	return written, err
+}
+
+// appendScalar appends the JSON of m that is neither array nor object.
+func appendScalar(buf []byte, m *Node, opts *formatOptions) []byte {
+	switch m.jsonType {
+	case Null:
+		return append(buf, "null"...)
+	case Bool:
+		if m.value.(bool) {
+			return append(buf, "true"...)
+		}
+		return append(buf, "false"...)
+	case Number:
+		return appendNumber(buf, m.value, opts.numbers)
+	case String:
+		return appendQuoted(buf, m.value.(string), opts.esc)
+	default:
+		return append(buf, "<error>"...)
+	}
+}
+
+// commaWidth returns the width of the comma after the i-th of n elements.
+func commaWidth(i, n int) int {
+	if i < n-1 {
+		return 1
+	}
+	return 0
 }
 
 // TODO(JMH): add case insensitive match on struct tags
@@ -701,13 +1008,12 @@ func json2Go(n *Node, val interface{}, stringify bool) (err error) {
 		inner.SetBool(n.value.(bool))
 		return nil
 	case reflect.Float64, reflect.Float32,
-		reflect.Int, reflect.Int64, reflect.Int32,
-		reflect.Uint, reflect.Uint64, reflect.Uint32:
+		reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8,
+		reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
 		if n.jsonType != Number {
 			return fmt.Errorf("mismatched type: want Number got %s", n.jsonType)
 		}
-		inner.Set(reflect.ValueOf(n.value).Convert(inner.Type()))
-		return nil
+		return setNumber(inner, numberOf(n.value))
 	case reflect.String:
 		if !stringify {
 			if n.jsonType != String {
@@ -728,7 +1034,7 @@ func json2Go(n *Node, val interface{}, stringify bool) (err error) {
 			inner.SetString("false")
 			return nil
 		case Number:
-			inner.SetString(strconv.FormatFloat(n.value.(float64), 'b', -1, 64))
+			inner.SetString(string(numberOf(n.value)))
 			return nil
 		case String:
 			inner.SetString(n.value.(string))
@@ -755,9 +1061,11 @@ func json2Go(n *Node, val interface{}, stringify bool) (err error) {
 			}
 		}()
 		for _, m := range nn {
-			inner.Set(reflect.Append(inner, reflect.
-				ValueOf(m.value).
-				Convert(t)))
+			elem := reflect.New(t).Elem()
+			if err := setElem(elem, m); err != nil {
+				return err
+			}
+			inner.Set(reflect.Append(inner, elem))
 		}
 		return nil
 	case reflect.Struct:
@@ -834,9 +1142,11 @@ func json2Go(n *Node, val interface{}, stringify bool) (err error) {
 			}
 		}()
 		for _, nn := range n.value.([]KeyNode) {
-			inner.SetMapIndex(reflect.ValueOf(nn.Key), reflect.
-				ValueOf(nn.value).
-				Convert(t.Elem()))
+			elem := reflect.New(t.Elem()).Elem()
+			if err := setElem(elem, nn.Node); err != nil {
+				return err
+			}
+			inner.SetMapIndex(reflect.ValueOf(nn.Key), elem)
 		}
 		return nil
 	default:
@@ -844,6 +1154,70 @@ func json2Go(n *Node, val interface{}, stringify bool) (err error) {
 	}
 }
 
+// setElem stores n in the addressable element v of a slice or map.
+// Elements of interface type get numbers as float64.
+func setElem(v reflect.Value, n *Node) error {
+	if v.Kind() == reflect.Interface {
+		v.Set(reflect.ValueOf(scalarOf(n)).Convert(v.Type()))
+		return nil
+	}
+	return json2Go(n, v.Addr().Interface(), false)
+}
+
+// setNumber stores num in the numeric value v. Numbers that do not fit
+// into v are an error. Integers may be written with fraction or exponent
+// as long as their value is integral.
+func setNumber(v reflect.Value, num JSONNumber) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+		i, err := integerOf(num)
+		if err != nil {
+			return err
+		}
+		if !i.IsInt64() || v.OverflowInt(i.Int64()) {
+			return fmt.Errorf("number %s overflows %s", num, v.Type())
+		}
+		v.SetInt(i.Int64())
+	case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		i, err := integerOf(num)
+		if err != nil {
+			return err
+		}
+		if !i.IsUint64() || v.OverflowUint(i.Uint64()) {
+			return fmt.Errorf("number %s overflows %s", num, v.Type())
+		}
+		v.SetUint(i.Uint64())
+	default:
+		f, err := strconv.ParseFloat(string(num), v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("number %s overflows %s", num, v.Type())
+		}
+		v.SetFloat(f)
+	}
+	return nil
+}
+
+// integerOf returns num as big.Int. Numbers far beyond 64 bits are an
+// error before their digits are computed.
+func integerOf(num JSONNumber) (*big.Int, error) {
+	if i, err := strconv.ParseInt(string(num), 10, 64); err == nil {
+		return big.NewInt(i), nil
+	}
+	if f, _ := num.Float64(); !(math.Abs(f) < 1<<65) {
+		return nil, fmt.Errorf("number %s overflows 64 bits", num)
+	}
+	return num.BigInt()
+}
+
+// scalarOf returns the value of n with numbers as float64.
+func scalarOf(n *Node) interface{} {
+	if n.jsonType == Number {
+		f, _ := numberOf(n.value).Float64()
+		return f
+	}
+	return n.value
+}
+
 func isValid(n *Node) bool {
 	if n == nil {
 		return false
@@ -853,7 +1227,7 @@ func isValid(n *Node) bool {
 		return n.jsonType == Null || n.jsonType == Error
 	case bool:
 		return n.jsonType == Bool
-	case float64:
+	case JSONNumber, float64:
 		return n.jsonType == Number
 	case string:
 		return n.jsonType == String
@@ -892,6 +1266,20 @@ func cyclicTest(n *Node) bool {
 	}
 }
 
+// nthKey returns the k-th (zero based) node with key in kn or nil.
+func nthKey(kn []KeyNode, key string, k int) *Node {
+	for i := range kn {
+		if kn[i].Key != key {
+			continue
+		}
+		if k == 0 {
+			return kn[i].Node
+		}
+		k--
+	}
+	return nil
+}
+
 // -> Root
 func maxParent(n *Node) *Node {
 	if n == nil || n.parent == nil {
@@ -13,8 +13,8 @@ func BenchmarkLexer(b *testing.B) {
 	{{]]                                "fasfaf"::true:,,""{}[125421525426]
 	0.53123[]{{{}null,,,,,,,,"hibas"::5::false[[{{}}       `
 	for i := 0; i < b.N; i++ {
-		lexc, _ := lex(strings.NewReader(input))
-		for t := range lexc {
+		l := lex(strings.NewReader(input))
+		for t, ok := l.next(); ok; t, ok = l.next() {
 			if t.Type == errToken {
 				b.Fatal(fmt.Sprintf("errounus token: %s", t))
 			}
@@ -27,9 +27,9 @@ func BenchmarkParser(b *testing.B) {
 	"n":{"bool":true,"obj":{"v":null},"values":[{"a":5,"b":"hi","c":5.8,
 	"d":null,"e":true},{"a":[5,6,7,8],"b":"hi2","c":5.9,"d":{
 	"f":"Hello there!"},"e":false}]}}`
-	lexc, _ := lex(strings.NewReader(input))
+	l := lexBytes([]byte(input))
 	lexs := make([]token, 0, 512)
-	for tk := range lexc {
+	for tk, ok := l.next(); ok; tk, ok = l.next() {
 		if tk.Type == errToken {
 			b.Fatal("non-valid token stream")
 		}
@@ -38,19 +38,41 @@ func BenchmarkParser(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		inpc := make(chan token, len(lexs))
-		for _, tk := range lexs {
-			inpc <- tk
+		_, err := parse(replay(lexs))
+		if err != nil {
+			b.Fatalf("non-valid token stream: %v", err)
 		}
-		close(inpc)
+	}
+}
 
-		_, err := parse(inpc, func() {})
+func BenchmarkLexParse(b *testing.B) {
+	input := `{"a":{"ab":[]},"b":[0,true,{}],"c":null,"d":0,"e":"",
+	"n":{"bool":true,"obj":{"v":null},"values":[{"a":5,"b":"hi","c":5.8,
+	"d":null,"e":true},{"a":[5,6,7,8],"b":"hi2","c":5.9,"d":{
+	"f":"Hello there!"},"e":false}]}}`
+	data := []byte(input)
+	for i := 0; i < b.N; i++ {
+		_, err := parse(lexBytes(data))
 		if err != nil {
 			b.Fatalf("non-valid token stream: %v", err)
 		}
 	}
 }
 
+// replay returns a lexer emitting toks again without reading any input.
+func replay(toks []token) *lexer {
+	var mode lexFunc
+	mode = func(l *lexer) lexFunc {
+		if len(toks) == 0 {
+			return nil
+		}
+		l.tok, l.ready = toks[0], true
+		toks = toks[1:]
+		return mode
+	}
+	return &lexer{mode: mode}
+}
+
 func BenchmarkFormat(b *testing.B) {
 	input := `{"a":{"ab":[]},"b":[0,true,{}],"c":null,"d":0,"e":"",
 	"n":{"bool":true,"obj":{"v":null},"values":[{"a":5,"b":"hi","c":5.8,
@@ -62,7 +84,7 @@ func BenchmarkFormat(b *testing.B) {
 	}
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err = n.format(ioutil.Discard, "~~", "^", "__", "==")
+		_, err = n.format(ioutil.Discard, formatOptions{indent: "~~", newline: "^", commaSep: "__", colonSep: "=="})
 		if err != nil {
 			b.Fatal(err)
 		}
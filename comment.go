@@ -0,0 +1,108 @@
+package airp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CommentPlacement is an enum for the places of a comment relative to the
+// Node it is attached to.
+type CommentPlacement uint8
+
+// CommentPlacements of a comment. The zero value places it before the node.
+const (
+	// LeadingComment is written on its own line before the node. For object
+	// members it is written before the key.
+	LeadingComment CommentPlacement = iota
+	// TrailingComment is written after the node and its comma on the same
+	// line.
+	TrailingComment
+	// InnerComment is written on its own line before the closing bracket of
+	// an array or object.
+	InnerComment
+)
+
+// comments holds the comments attached to a Node.
+type comments [3][]string
+
+// Comments returns the comments of n at where. Each comment includes its
+// delimiters like "// note" or "/* note */".
+func (n *Node) Comments(where CommentPlacement) []string {
+	if n.comments == nil || int(where) >= len(n.comments) {
+		return nil
+	}
+	return n.comments[where]
+}
+
+// AddComment appends comment to the comments of n at where. comment has to
+// be a complete line or block comment including its delimiters. Only arrays
+// and objects have inner comments.
+func (n *Node) AddComment(where CommentPlacement, comment string) error {
+	if int(where) >= len(comments{}) {
+		return fmt.Errorf("unknown comment placement %d", where)
+	}
+	if where == InnerComment && n.jsonType != Array && n.jsonType != Object {
+		return fmt.Errorf("inner comment in %s", n.jsonType)
+	}
+	if !validComment(comment) {
+		return fmt.Errorf("invalid comment %q", comment)
+	}
+	n.addComment(where, comment)
+	n.touchComments(where)
+	return nil
+}
+
+// RemoveComments removes all comments of n at where.
+func (n *Node) RemoveComments(where CommentPlacement) {
+	if n.comments == nil || int(where) >= len(n.comments) {
+		return
+	}
+	n.comments[where] = nil
+	n.touchComments(where)
+	if !n.comments.any() {
+		n.comments = nil
+	}
+}
+
+// addComment adds comments without checking them.
+func (n *Node) addComment(where CommentPlacement, cc ...string) {
+	if n.comments == nil {
+		n.comments = new(comments)
+	}
+	n.comments[where] = append(n.comments[where], cc...)
+}
+
+// validComment reports whether c is exactly one comment.
+func validComment(c string) bool {
+	l := lexBytes([]byte(c))
+	l.comments, l.trivia = true, true
+	t, ok := l.next()
+	if !ok || t.Type != commentToken || t.value != c {
+		return false
+	}
+	_, ok = l.next()
+	return !ok
+}
+
+// copy returns a deep copy of c.
+func (c *comments) copy() *comments {
+	if c == nil {
+		return nil
+	}
+	d := new(comments)
+	for i, cc := range c {
+		d[i] = append([]string(nil), cc...)
+	}
+	return d
+}
+
+// any reports whether c holds a comment.
+func (c *comments) any() bool {
+	return c != nil && len(c[0])+len(c[1])+len(c[2]) > 0
+}
+
+// isLineComment reports whether c is a // comment which needs a line break
+// after it.
+func isLineComment(c string) bool {
+	return strings.HasPrefix(c, "//")
+}
@@ -0,0 +1,150 @@
+package airp
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// ctxReader is a reader that stops when its context is done. A read of a
+// reader that cannot be interrupted is abandoned and ends in the
+// background.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+	buf []byte // owned by the underlying reader during a read
+}
+
+// ioResult is the result of a call of Read or Write.
+type ioResult struct {
+	n   int
+	err error
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.ctx.Done() == nil {
+		return c.r.Read(p) // never done
+	}
+	read := func() (int, error) { return c.r.Read(p) }
+	switch r := c.r.(type) {
+	case interface{ SetReadDeadline(time.Time) error }:
+		return interrupt(c.ctx, read, func() { r.SetReadDeadline(time.Unix(1, 0)) })
+	case io.Closer:
+		return interrupt(c.ctx, read, func() { r.Close() })
+	}
+	if cap(c.buf) < len(p) {
+		c.buf = make([]byte, len(p))
+	}
+	buf := c.buf[:len(p)]
+	res := make(chan ioResult, 1)
+	go func() {
+		n, err := c.r.Read(buf)
+		res <- ioResult{n, err}
+	}()
+	select {
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	case r := <-res:
+		return copy(p, buf[:r.n]), r.err
+	}
+}
+
+// ctxWriter is a writer that stops when its context is done. A write to a
+// writer that cannot be interrupted is abandoned and ends in the
+// background.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if c.ctx.Done() == nil {
+		return c.w.Write(p) // never done
+	}
+	write := func() (int, error) { return c.w.Write(p) }
+	switch w := c.w.(type) {
+	case interface{ SetWriteDeadline(time.Time) error }:
+		return interrupt(c.ctx, write, func() { w.SetWriteDeadline(time.Unix(1, 0)) })
+	case io.Closer:
+		return interrupt(c.ctx, write, func() { w.Close() })
+	}
+	p = append([]byte(nil), p...) // the write may outlast the call
+	res := make(chan ioResult, 1)
+	go func() {
+		n, err := c.w.Write(p)
+		res <- ioResult{n, err}
+	}()
+	select {
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	case r := <-res:
+		return r.n, r.err
+	}
+}
+
+// interrupt calls do and calls stop to make it return if ctx is done
+// before. The error of ctx is returned then. The goroutine waiting for ctx
+// ends with the call.
+func interrupt(ctx context.Context, do func() (int, error), stop func()) (int, error) {
+	done := make(chan struct{})
+	stopped := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+			stopped <- true
+		case <-done:
+			stopped <- false
+		}
+	}()
+	n, err := do()
+	close(done)
+	if <-stopped {
+		return n, ctx.Err()
+	}
+	return n, err
+}
+
+// NewJSONReaderContext reads from r like NewJSONReader. It stops with the
+// error of ctx when ctx is done. If ctx is done during a read, r is closed
+// if it is an io.Closer without SetReadDeadline method. See
+// ParseOptions.NewJSONReaderContext.
+func NewJSONReaderContext(ctx context.Context, r io.Reader) (*Node, error) {
+	return ParseOptions{}.NewJSONReaderContext(ctx, r)
+}
+
+// NewJSONReaderContext reads from r like NewJSONReader. It stops with the
+// error of ctx when ctx is done. A read in progress is stopped with a read
+// deadline in the past if r has a SetReadDeadline method like net.Conn.
+// The deadline stays set. Otherwise r is closed if it is an io.Closer and
+// cannot be read any more, even if the parse would have ended with the
+// read. Other readers cannot be stopped. The read is abandoned then and keeps a
+// goroutine until it returns.
+func (o ParseOptions) NewJSONReaderContext(ctx context.Context, r io.Reader) (*Node, error) {
+	return o.NewJSONReader(&ctxReader{ctx: ctx, r: r})
+}
+
+// EncodeContext writes n to w like Encode. It stops with the error of ctx
+// when ctx is done. A write in progress is stopped with a write deadline in
+// the past if w has a SetWriteDeadline method like net.Conn. The deadline
+// stays set. Otherwise w is closed if it is an io.Closer and cannot be
+// written any more. Other writers cannot be stopped. The write is abandoned then and keeps a goroutine
+// until it returns.
+func (n *Node) EncodeContext(ctx context.Context, w io.Writer, opts EncodeOptions) (int, error) {
+	return n.format(ctxWriter{ctx: ctx, w: w}, opts.formatOptions())
+}
+
+// EncodeContext writes n to the stream of e like Encode. It stops with the
+// error of ctx when ctx is done like Node.EncodeContext, which closes the
+// writer of e if it is an io.Closer without SetWriteDeadline method. The
+// stream may end with a part of n then.
+func (e *Encoder) EncodeContext(ctx context.Context, n *Node) error {
+	_, err := n.format(ctxWriter{ctx: ctx, w: e.w}, e.opts)
+	return err
+}
@@ -0,0 +1,140 @@
+package airp
+
+import "strings"
+
+// cst records the concrete syntax of a node parsed with
+// ParseOptions.Lossless. It allows to write unchanged parts of the input
+// byte by byte.
+type cst struct {
+	src        []byte   // the whole input
+	jsonType   JSONType // type as parsed
+	start, end int      // offsets of the value in src
+	keyStart   int      // offset of the key of object members; else start
+	keyEnd     int      // end of the key of object members
+	key        string   // key as parsed
+	owner      *cst     // syntax of the parent as parsed
+	idx        int      // index in the parent as parsed
+	first      int      // offset of the first child of arrays and objects
+	last       int      // end of the last child of arrays and objects
+	len        int      // number of children as parsed
+	sep        string   // white space after the commas between children; len > 1
+	colonSep   string   // white space after the colons of objects
+	dirty      bool     // node or children changed
+	comments   uint8    // set of CommentPlacements that changed
+}
+
+// touch marks n and all its parents as changed.
+func (n *Node) touch() {
+	for m := n; m != nil; m = m.parent {
+		if m.cst != nil {
+			m.cst.dirty = true
+		}
+	}
+}
+
+// touchComments marks the comments of n at where as changed.
+func (n *Node) touchComments(where CommentPlacement) {
+	if n.cst != nil {
+		n.cst.comments |= 1 << where
+	}
+	if where == InnerComment {
+		n.touch()
+	} else {
+		n.parent.touch()
+	}
+}
+
+// copy returns a copy of c that belongs to a new tree.
+func (c *cst) copy() *cst {
+	if c == nil {
+		return nil
+	}
+	d := *c
+	return &d
+}
+
+// adopt moves c from the parent from to the copy to of it.
+func (c *cst) adopt(from, to *cst) {
+	if c != nil && c.owner == from {
+		c.owner = to
+	}
+}
+
+// text returns the input from start to end.
+func (c *cst) text(start, end int) []byte {
+	return c.src[start:end]
+}
+
+// commentsChanged reports whether the comments of n at where changed
+// since parsing.
+func (c *cst) commentsChanged(where CommentPlacement) bool {
+	return c.comments&(1<<where) != 0
+}
+
+// preserved reports whether n can be written using its input.
+func (n *Node) preserved() bool {
+	c := n.cst
+	if c == nil {
+		return false
+	}
+	if !c.dirty {
+		return true
+	}
+	// changed arrays and objects are written piece by piece
+	return c.jsonType == n.jsonType && c.len > 0 && n.Len() > 0 &&
+		(n.jsonType == Array || n.jsonType == Object)
+}
+
+// adjacent reports whether a and b are unchanged neighbours in the input
+// of c so that the input between them can be kept.
+func (c *cst) adjacent(a, b *Node) bool {
+	return a.cst != nil && c.child(a, a.cst.idx) && c.child(b, a.cst.idx+1)
+}
+
+// child reports whether m is the idx-th child of the parsed node c with
+// unchanged comments around it.
+func (c *cst) child(m *Node, idx int) bool {
+	return m.cst != nil && m.cst.owner == c && m.cst.idx == idx &&
+		!m.cst.commentsChanged(LeadingComment) &&
+		!m.cst.commentsChanged(TrailingComment)
+}
+
+// space returns the white space at the end of gap. It starts with the last
+// line break of gap.
+func space(gap []byte) string {
+	i := len(gap)
+	for i > 0 && (gap[i-1] == ' ' || gap[i-1] == '\t') {
+		i--
+	}
+	if i > 0 && gap[i-1] == '\n' {
+		i--
+		if i > 0 && gap[i-1] == '\r' {
+			i--
+		}
+	}
+	return string(gap[i:])
+}
+
+// layout returns the options to write new parts of the parsed array or
+// object c in the style of the input. Children are written at level 0.
+// open follows the opening and closing precedes the closing bracket.
+func (c *cst) layout(o formatOptions) (g formatOptions, open, closing string) {
+	g = o
+	if c.jsonType == Object {
+		g.colonSep = c.colonSep
+	}
+	open = space(c.text(c.start+1, c.first))
+	closing = space(c.text(c.last, c.end-1))
+	if !strings.ContainsRune(open, '\n') {
+		g.newline, g.indent, g.commaSep = "", "", c.sep
+		if c.len < 2 { // no comma to follow
+			g.commaSep = " "
+		}
+		return g, open, closing
+	}
+	g.newline, g.commaSep = open, ""
+	if strings.HasPrefix(open, closing) && len(open) > len(closing) {
+		g.indent = open[len(closing):]
+	}
+	return g, open, closing
+}
@@ -0,0 +1,406 @@
+package airp
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Position is a location in a JSON input. Line and Column are zero based,
+// Column counts runes. Offset counts bytes from the start of the input.
+type Position struct {
+	Line, Column int
+	Offset       int
+}
+
+// TokenKind is an enum for the kinds of tokens a Decoder yields.
+type TokenKind uint8
+
+//go:generate stringer -type TokenKind
+
+// TokenKinds of a Token. The zero value signals invalid.
+const (
+	InvalidKind TokenKind = iota
+	DelimKind
+	KeyKind
+	StringKind
+	NumberKind
+	BoolKind
+	NullKind
+)
+
+// Token is a single element of a JSON document read by a Decoder.
+// Value holds depending on Kind:
+//
+//	DelimKind  one of "{", "}", "[", "]"
+//	KeyKind    the object key
+//	StringKind the string
+//	NumberKind the number as written in the input; JSON5 numbers are
+//	           converted like the parser does
+//	BoolKind   "true" or "false"
+//	NullKind   "null"
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Pos   Position
+}
+
+// Decoder reads a JSON document token by token without building an AST.
+// Commas and colons are checked but not returned. Object keys are not
+// checked for uniqueness.
+// A Decoder created by ParseOptions.NewDecoder validates keys like the
+// parser does.
+type Decoder struct {
+	lex    *lexer
+	opts   ParseOptions
+	state  decodeState
+	stack  []decodeFrame
+	prev   token
+	peek   token
+	peekOK bool
+	peeked bool
+	err    error
+}
+
+type decodeState uint8
+
+const (
+	decodeValue       decodeState = iota // a value
+	decodeArrayValue                     // a value or ]
+	decodeArrayComma                     // , or ]
+	decodeObjectKey                      // a key or }
+	decodeKey                            // a key
+	decodeColon                          // :
+	decodeObjectComma                    // , or }
+	decodeEnd                            // end of input
+)
+
+// decodeFrame is an open array or object.
+type decodeFrame struct {
+	jsonType JSONType
+	key      string
+	index    int
+}
+
+// NewDecoder creates a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return ParseOptions{}.NewDecoder(r)
+}
+
+// Token returns the next token of the input. After the document is
+// complete it returns io.EOF. Syntax errors are of type *ParseError.
+// Errors of the reader are returned as they are.
+func (d *Decoder) Token() (Token, error) {
+	if d.err != nil {
+		return Token{}, d.err
+	}
+	for {
+		t, ok := d.read()
+		if d.lex.readErr != nil {
+			d.err = d.lex.readErr
+			return Token{}, d.err
+		}
+		if !ok {
+			if d.state == decodeEnd {
+				return Token{}, io.EOF
+			}
+			if d.expected() == "delimiter" {
+				t = d.prev
+			}
+			pErr := d.fail(d.expected(), t)
+			pErr.kind = ErrUnexpectedEOF
+			return Token{}, pErr
+		}
+		switch d.state {
+		case decodeValue, decodeArrayValue:
+			if d.state == decodeArrayValue && t.Type == arrayCToken {
+				return d.close(t), nil
+			}
+			return d.value(t)
+		case decodeArrayComma:
+			switch t.Type {
+			case commaToken:
+				d.comma(t)
+				continue
+			case arrayCToken:
+				return d.close(t), nil
+			case objectCToken:
+				return Token{}, d.fail("array closing", t)
+			}
+		case decodeObjectKey, decodeKey:
+			if d.state == decodeObjectKey && t.Type == objectCToken {
+				return d.close(t), nil
+			}
+			if t.Type == stringToken || d.opts.Syntax == SyntaxJSON5 && t.identifier() {
+				if d.opts.ValidateKey != nil {
+					if err := d.opts.ValidateKey(t.value); err != nil {
+						pErr := d.fail("valid key", t)
+						pErr.err = err
+						return Token{}, pErr
+					}
+				}
+				d.stack[len(d.stack)-1].key = t.value
+				d.state = decodeColon
+				d.prev = t
+				return Token{Kind: KeyKind, Value: t.value, Pos: t.pos()}, nil
+			}
+		case decodeColon:
+			if t.Type == colonToken {
+				d.state = decodeValue
+				d.prev = t
+				continue
+			}
+		case decodeObjectComma:
+			switch t.Type {
+			case commaToken:
+				d.comma(t)
+				continue
+			case objectCToken:
+				return d.close(t), nil
+			case arrayCToken:
+				return Token{}, d.fail("object closing", t)
+			}
+		}
+		return Token{}, d.fail(d.expected(), t)
+	}
+}
+
+// More reports whether there is another element in the current array or
+// object. At the top-level it reports whether the document is not yet
+// complete. A trailing comma is not another element.
+func (d *Decoder) More() bool {
+	if d.err != nil {
+		return false
+	}
+	d.peekNext()
+	if d.peekOK && d.peek.Type == commaToken && d.opts.trailingCommas() &&
+		(d.state == decodeArrayComma || d.state == decodeObjectComma) {
+		d.peeked = false
+		d.comma(d.peek)
+		d.peekNext()
+	}
+	return d.peekOK && d.peek.Type != arrayCToken && d.peek.Type != objectCToken
+}
+
+// Skip discards the next value including all of its children. If the next
+// token is an object key, the key and its value are discarded.
+// At the end of an array or object Skip does nothing.
+func (d *Decoder) Skip() error {
+	if !d.More() {
+		if d.err == nil && d.peekOK {
+			return nil
+		}
+		_, err := d.Token()
+		return err
+	}
+	depth := 0
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if t.Kind == DelimKind {
+			switch t.Value {
+			case "{", "[":
+				depth++
+			default:
+				depth--
+			}
+		}
+		if depth == 0 && t.Kind != KeyKind {
+			return nil
+		}
+	}
+}
+
+// peekNext reads the next token ahead unless it was read already.
+func (d *Decoder) peekNext() {
+	if !d.peeked {
+		d.peek, d.peekOK = d.lex.next()
+		d.peeked = true
+	}
+}
+
+func (d *Decoder) read() (token, bool) {
+	if d.peeked {
+		d.peeked = false
+		return d.peek, d.peekOK
+	}
+	return d.lex.next()
+}
+
+func (d *Decoder) value(t token) (Token, error) {
+	d.prev = t
+	tk := Token{Value: t.value, Pos: t.pos()}
+	if (t.Type == arrayOToken || t.Type == objectOToken) && len(d.stack) == d.opts.Limits.depth() {
+		return Token{}, d.exceeded(ErrDepthExceeded, t)
+	}
+	switch t.Type {
+	case numberToken, identToken:
+		v, ok := parseNumber(t.value, d.opts.Syntax)
+		if !ok && t.Type == identToken {
+			return Token{}, d.fail("value", t)
+		} else if !ok {
+			pErr := d.fail("number", t)
+			pErr.kind = ErrInvalidNumber
+			return Token{}, pErr
+		}
+		tk.Kind = NumberKind
+		tk.Value = string(appendNumber(nil, v, NumberLiteral))
+	case stringToken:
+		tk.Kind = StringKind
+	case nullToken:
+		tk.Kind, tk.Value = NullKind, "null"
+	case trueToken:
+		tk.Kind, tk.Value = BoolKind, "true"
+	case falseToken:
+		tk.Kind, tk.Value = BoolKind, "false"
+	case arrayOToken:
+		d.stack = append(d.stack, decodeFrame{jsonType: Array})
+		d.state = decodeArrayValue
+		return Token{Kind: DelimKind, Value: "[", Pos: t.pos()}, nil
+	case objectOToken:
+		d.stack = append(d.stack, decodeFrame{jsonType: Object})
+		d.state = decodeObjectKey
+		return Token{Kind: DelimKind, Value: "{", Pos: t.pos()}, nil
+	default:
+		return Token{}, d.fail("value", t)
+	}
+	d.next()
+	return tk, nil
+}
+
+// close pops the current array or object.
+func (d *Decoder) close(t token) Token {
+	d.prev = t
+	d.stack = d.stack[:len(d.stack)-1]
+	d.next()
+	if t.Type == arrayCToken {
+		return Token{Kind: DelimKind, Value: "]", Pos: t.pos()}
+	}
+	return Token{Kind: DelimKind, Value: "}", Pos: t.pos()}
+}
+
+// comma continues after the comma t following an element.
+func (d *Decoder) comma(t token) {
+	d.prev = t
+	if d.state == decodeArrayComma {
+		d.stack[len(d.stack)-1].index++
+		d.state = decodeValue
+		if d.opts.trailingCommas() {
+			d.state = decodeArrayValue
+		}
+		return
+	}
+	d.state = decodeKey
+	if d.opts.trailingCommas() {
+		d.state = decodeObjectKey
+	}
+}
+
+// next sets the state after a complete value.
+func (d *Decoder) next() {
+	switch {
+	case len(d.stack) == 0:
+		d.state = decodeEnd
+	case d.stack[len(d.stack)-1].jsonType == Array:
+		d.state = decodeArrayComma
+	default:
+		d.state = decodeObjectComma
+	}
+}
+
+// expected describes what the decoder waits for in its current state.
+func (d *Decoder) expected() string {
+	switch d.state {
+	case decodeValue, decodeArrayValue:
+		return "value"
+	case decodeObjectKey, decodeKey:
+		return "key"
+	case decodeColon:
+		return "colon"
+	default:
+		return "delimiter"
+	}
+}
+
+func (d *Decoder) fail(msg string, t token) *ParseError {
+	e := &ParseError{
+		kind:       tokenErr(t),
+		msg:        msg,
+		token:      t,
+		before:     d.prev,
+		parentType: Error,
+		key:        d.path(),
+	}
+	if len(d.stack) > 0 {
+		e.parentType = d.stack[len(d.stack)-1].jsonType
+	}
+	e.complete(d.lex)
+	d.err = e
+	return e
+}
+
+// exceeded fails with the error of kind for the token t beyond a limit.
+func (d *Decoder) exceeded(kind error, t token) *ParseError {
+	pErr := d.fail("", t)
+	pErr.kind = kind
+	pErr.complete(d.lex) // again for the kind
+	return pErr
+}
+
+// path returns the key of the current location in the style of Node.Key.
+func (d *Decoder) path() string {
+	return d.pathTo(len(d.stack))
+}
+
+// pathTo returns the key of the location in the first n open arrays and
+// objects.
+func (d *Decoder) pathTo(n int) string {
+	ss := make([]string, n)
+	for i, f := range d.stack[:n] {
+		if f.jsonType == Array {
+			ss[i] = strconv.Itoa(f.index)
+		} else {
+			ss[i] = f.key
+		}
+	}
+	return strings.Join(ss, ".")
+}
+
+// skipNested discards the rest of the array or object whose opening
+// bracket was read last like skipOpen. The lexer skips the values in it
+// without lexing them and only checks their brackets, strings and
+// comments.
+func (d *Decoder) skipNested() error {
+	if d.peeked {
+		return d.skipOpen()
+	}
+	closer := '}'
+	if d.stack[len(d.stack)-1].jsonType == Array {
+		closer = ']'
+	}
+	d.lex.mode = nestedMode(closer, d.opts.Limits.depth()-len(d.stack)+1)
+	_, err := d.Token()
+	return err
+}
+
+// skipOpen discards the rest of the array or object whose opening bracket
+// was read last.
+func (d *Decoder) skipOpen() error {
+	for depth := 1; depth > 0; {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if t.Kind == DelimKind {
+			switch t.Value {
+			case "{", "[":
+				depth++
+			default:
+				depth--
+			}
+		}
+	}
+	return nil
+}
@@ -15,8 +15,5 @@ Some differences between this package and encoding/json:
       ([]/{}) instead of null
     - bytes slices will be interpreded as strings instead of as base64
       encoded data
-
-TODO(JMH): better handle escape sequences
-TODO(JMH): reimplement lexer and parser
 */
 package airp // import "github.com/d1ced/jsonparser_airp"
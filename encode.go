@@ -0,0 +1,154 @@
+package airp
+
+import (
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// EncodeOptions configures how a Node is written. The zero value writes
+// JSON without any whitespace like WriteJSON.
+type EncodeOptions struct {
+	// Indent is written once per nesting level at the start of each line.
+	// If it is empty arrays and objects are written on a single line.
+	Indent string
+	// Prefix is written at the start of each line after the first one.
+	// It has no effect without Indent.
+	Prefix string
+	// SpaceAfterColon writes a space between keys and values.
+	SpaceAfterColon bool
+	// SpaceAfterComma writes a space after commas. It has no effect with
+	// Indent as each comma ends a line then.
+	SpaceAfterComma bool
+	// SortKeys writes the members of all objects sorted by their keys.
+	// The Node itself is not changed.
+	SortKeys bool
+	// TrailingNewline ends the output with a newline.
+	TrailingNewline bool
+	// Escape controls how strings and keys are escaped.
+	Escape EscapeMode
+	// Numbers controls how numbers are written.
+	Numbers NumberStyle
+	// StripComments leaves out the comments attached to nodes.
+	StripComments bool
+	// Preserve writes the parts of a tree read with ParseOptions.Lossless
+	// that did not change exactly as they were read. Changed parts follow
+	// the layout of the input around them.
+	Preserve bool
+	// Width switches to a layout that writes arrays and objects on a single
+	// line if they fit into Width columns and breaks them up otherwise.
+	// Columns are counted in runes. It has no effect without Indent.
+	Width int
+	// JSON5 writes the numbers Infinity and NaN of SyntaxJSON5 as they are
+	// written in JSON5. JSON has no such numbers so they are an error
+	// otherwise.
+	JSON5 bool
+}
+
+// NumberStyle is an enum for the ways numbers can be written.
+type NumberStyle uint8
+
+// NumberStyles for EncodeOptions. The zero value keeps numbers as they
+// were read.
+const (
+	// NumberLiteral writes numbers as they were read.
+	NumberLiteral NumberStyle = iota
+	// NumberFloat64 writes the shortest form of the nearest float64 of
+	// each number. Numbers outside of the float64 range are written as
+	// they were read.
+	NumberFloat64
+)
+
+func (o EncodeOptions) formatOptions() formatOptions {
+	f := formatOptions{
+		esc:      o.Escape,
+		sortKeys: o.SortKeys,
+		numbers:  o.Numbers,
+
+		stripComments: o.StripComments,
+		preserve:      o.Preserve,
+		json5:         o.JSON5,
+	}
+	if o.Indent != "" {
+		f.indent = o.Indent
+		f.newline = "\n" + o.Prefix
+		f.width = o.Width
+	} else if o.SpaceAfterComma {
+		f.commaSep = " "
+	}
+	if o.SpaceAfterColon {
+		f.colonSep = " "
+	}
+	if o.TrailingNewline {
+		f.trailer = "\n"
+	}
+	return f
+}
+
+// Encode writes the AST hold by n to w as opts says.
+func (n *Node) Encode(w io.Writer, opts EncodeOptions) (int, error) {
+	return n.format(w, opts.formatOptions())
+}
+
+// Encoder writes Nodes to an output stream with the same options.
+type Encoder struct {
+	w    io.Writer
+	opts formatOptions
+}
+
+// NewEncoder creates an Encoder writing to w. Each value ends with a
+// newline like with TrailingNewline so that the values stay apart.
+func NewEncoder(w io.Writer, opts EncodeOptions) *Encoder {
+	opts.TrailingNewline = true
+	return &Encoder{w: w, opts: opts.formatOptions()}
+}
+
+// Encode writes n to the stream of e followed by a newline.
+func (e *Encoder) Encode(n *Node) error {
+	_, err := n.format(e.w, e.opts)
+	return err
+}
+
+// appendNumber appends the value of a Number node to buf. Infinity and
+// NaN are written as in JSON5.
+func appendNumber(buf []byte, v interface{}, style NumberStyle) []byte {
+	switch v := v.(type) {
+	case float64:
+		// only JSON5 has non-finite numbers
+		switch {
+		case math.IsInf(v, 1):
+			return append(buf, "Infinity"...)
+		case math.IsInf(v, -1):
+			return append(buf, "-Infinity"...)
+		case math.IsNaN(v):
+			return append(buf, "NaN"...)
+		}
+		return strconv.AppendFloat(buf, v, 'g', -1, 64)
+	case JSONNumber:
+		if style == NumberFloat64 {
+			if f, err := v.Float64(); err == nil {
+				return strconv.AppendFloat(buf, f, 'g', -1, 64)
+			}
+		}
+		return append(buf, v...)
+	default:
+		panic("invariant violation: number of unknown type")
+	}
+}
+
+// isFinite reports whether the value of a Number node is a JSON number.
+func isFinite(v interface{}) bool {
+	f, ok := v.(float64)
+	return !ok || !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// sortedKeys returns a copy of kn sorted by key.
+func sortedKeys(kn []KeyNode) []KeyNode {
+	sorted := make([]KeyNode, len(kn))
+	copy(sorted, kn)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
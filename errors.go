@@ -3,47 +3,72 @@ package airp
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // ErrNotArrayOrObject is a common error that multiple methods of Node
 // or KeyNode return. This signals that the Node type is a standalone value.
 var ErrNotArrayOrObject = errors.New("not array or object")
 
+// Kinds of ParseErrors. Use errors.Is to test the kind of an error.
+var (
+	// ErrUnexpectedToken is a token that is not allowed at its position.
+	ErrUnexpectedToken = errors.New("unexpected token")
+	// ErrUnexpectedEOF is the end of the input within a value, string or
+	// comment.
+	ErrUnexpectedEOF = errors.New("unexpected end of input")
+	// ErrInvalidEscape is a malformed escape sequence in a string.
+	ErrInvalidEscape = errors.New("invalid escape")
+	// ErrInvalidNumber is a number that does not follow the syntax.
+	ErrInvalidNumber = errors.New("invalid number")
+	// ErrDuplicateKey is a key used twice in one object with
+	// DuplicateError.
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrDepthExceeded is an array or object nested too deep.
+	ErrDepthExceeded = errors.New("maximum depth exceeded")
+	// ErrInputTooLarge is input beyond Limits.MaxBytes.
+	ErrInputTooLarge = errors.New("input too large")
+	// ErrStringTooLong is a string or key longer than
+	// Limits.MaxStringLength.
+	ErrStringTooLong = errors.New("string too long")
+	// ErrNumberTooLong is a number longer than Limits.MaxNumberLength.
+	ErrNumberTooLong = errors.New("number too long")
+	// ErrTooManyMembers is an object with more than Limits.MaxMembers
+	// members.
+	ErrTooManyMembers = errors.New("too many object members")
+	// ErrTooManyNodes is a value beyond Limits.MaxNodes.
+	ErrTooManyNodes = errors.New("too many nodes")
+)
+
 // ParseError captures information on errors when parsing.
 type ParseError struct {
+	kind       error
 	msg        string
 	token      token
 	before     token
 	parentType JSONType
 	key        string
-}
-
-func newParseError(msg string, before, after token, ast *Node) *ParseError {
-	parent := parentType(ast)
-	key := ast.Key()
-	return &ParseError{
-		msg:        msg,
-		before:     before,
-		token:      after,
-		parentType: parent,
-		key:        key,
-	}
+	err        error
+	line       string // input around token
+	col        int    // column of token in line
 }
 
 func (e *ParseError) Error() string {
-	if e.before == (token{}) {
-		return fmt.Sprintf("%s; expected %s", e.token.Error(), e.msg)
+	row, col := e.Where()
+	s := fmt.Sprintf("%d:%d: %v", row, col, e.kind)
+	if text := e.token.text(); text != "" && e.kind != ErrUnexpectedEOF {
+		s += " '" + text + "'"
 	}
-	if e.parentType == Error {
-		return fmt.Sprintf("%s; expected %s token after %s",
-			e.token.Error(), e.msg, e.before.String())
+	if e.msg != "" {
+		s += "; expected " + e.msg
 	}
-	if e.key == "" {
-		return fmt.Sprintf("%s; expected %s token after %s (in top-level %s)",
-			e.token.Error(), e.msg, e.before.String(), e.parentType.String())
+	if e.key != "" {
+		s += fmt.Sprintf(" (at %s in %s)", e.key, e.parentType)
 	}
-	return fmt.Sprintf("%s; expected %s token after %s (at %s in %s)",
-		e.token.Error(), e.msg, e.before.String(), e.key, e.parentType)
+	if e.err != nil {
+		s += ": " + e.err.Error()
+	}
+	return s
 }
 
 // Where returns the row and column where the syntax error in json occurred.
@@ -51,6 +76,109 @@ func (e *ParseError) Where() (row, col int) {
 	return e.token.position[0], e.token.position[1]
 }
 
+// Offset returns the byte offset in the input where the syntax error
+// occurred.
+func (e *ParseError) Offset() int {
+	return e.token.span[0].Offset
+}
+
+// Snippet returns the line of the input where the error occurred and a
+// second line with a caret under the column of the error. Long lines are
+// cut around the error.
+func (e *ParseError) Snippet() string {
+	b := make([]byte, 0, 2*len(e.line)+2)
+	b = append(b, e.line...)
+	b = append(b, '\n')
+	i := 0
+	for _, r := range e.line {
+		if i == e.col {
+			break
+		}
+		if r == '\t' {
+			b = append(b, '\t')
+		} else {
+			b = append(b, ' ')
+		}
+		i++
+	}
+	return string(append(b, '^'))
+}
+
+// complete adds the kind of error tokens and the excerpt of the input
+// from the lexer l that emitted the tokens of e. The lexer clears the kind
+// with the token at the end of the input.
+func (e *ParseError) complete(l *lexer) {
+	if e.token.Type == errToken && l.err != nil {
+		e.kind = l.err
+	}
+	if isLimit(e.kind) {
+		e.msg = "" // any token may exceed a limit
+		e.key = shortKey(e.key)
+	}
+	e.line, e.col = l.excerpt(e.token.pos())
+}
+
+// maxKeyParts is the number of components of the key kept in errors of
+// exceeding Limits.
+const maxKeyParts = 3
+
+// shortKey returns the last maxKeyParts components of key. The key of a
+// value nested as deep as Limits allow may be as large as the input.
+func shortKey(key string) string {
+	i := len(key)
+	for n := 0; n < maxKeyParts; n++ {
+		if i = strings.LastIndexByte(key[:i], '.'); i < 0 {
+			return key
+		}
+	}
+	return "..." + key[i+1:]
+}
+
+// isLimit reports whether kind is the error of exceeding Limits.
+func isLimit(kind error) bool {
+	switch kind {
+	case ErrDepthExceeded, ErrInputTooLarge, ErrStringTooLong,
+		ErrNumberTooLong, ErrTooManyMembers, ErrTooManyNodes:
+		return true
+	}
+	return false
+}
+
+// Is reports whether target is the kind of e like ErrUnexpectedToken.
+func (e *ParseError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the error that caused e if any. This is the error of a
+// key validator.
+func (e *ParseError) Unwrap() error {
+	return e.err
+}
+
+// ParseErrors lists the errors a tolerant parser found in the order of the
+// input.
+type ParseErrors []*ParseError
+
+func (e ParseErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e[0], len(e)-1)
+}
+
+// Is reports whether one of the errors in e is target.
+func (e ParseErrors) Is(target error) bool {
+	for _, pErr := range e {
+		if errors.Is(pErr, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // helper functions
 
 func parentType(n *Node) JSONType {
@@ -59,3 +187,12 @@ func parentType(n *Node) JSONType {
 	}
 	return n.parent.jsonType
 }
+
+// tokenErr returns the kind of error if t is not expected. The kind of
+// error tokens is set by complete.
+func tokenErr(t token) error {
+	if t.Type == errToken {
+		return ErrUnexpectedEOF
+	}
+	return ErrUnexpectedToken
+}
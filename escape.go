@@ -0,0 +1,98 @@
+package airp
+
+import (
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// EscapeMode is a set of flags that control how strings are escaped when
+// JSON is written. The zero value escapes only what RFC 8259 requires:
+// quotation mark, reverse solidus and control characters.
+type EscapeMode uint8
+
+// EscapeModes can be combined with |.
+const (
+	// EscapeASCII escapes every non-ASCII character so that the output is
+	// pure ASCII.
+	EscapeASCII EscapeMode = 1 << iota
+	// EscapeHTML escapes <, >, &, U+2028 and U+2029 so that the output can
+	// be embedded in HTML <script> tags.
+	EscapeHTML
+)
+
+const hexDigits = "0123456789abcdef"
+
+// appendQuoted appends s as a quoted and escaped JSON string to buf.
+func appendQuoted(buf []byte, s string, mode EscapeMode) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if !needsEscape(b, mode) {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = appendEscapedRune(buf, rune(b))
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			// invalid UTF-8 is replaced like encoding/json does
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, `\ufffd`...)
+		case mode&EscapeASCII != 0,
+			mode&EscapeHTML != 0 && (r == '\u2028' || r == '\u2029'):
+			buf = append(buf, s[start:i]...)
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				buf = appendEscapedRune(buf, r1)
+				buf = appendEscapedRune(buf, r2)
+			} else {
+				buf = appendEscapedRune(buf, r)
+			}
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+func needsEscape(b byte, mode EscapeMode) bool {
+	switch {
+	case b < 0x20, b == '"', b == '\\':
+		return true
+	case mode&EscapeHTML != 0:
+		return b == '<' || b == '>' || b == '&'
+	default:
+		return false
+	}
+}
+
+// appendEscapedRune appends r < 0x10000 as \uXXXX escape sequence.
+func appendEscapedRune(buf []byte, r rune) []byte {
+	return append(buf, '\\', 'u',
+		hexDigits[r>>12&0xf], hexDigits[r>>8&0xf],
+		hexDigits[r>>4&0xf], hexDigits[r&0xf])
+}
@@ -1,7 +1,10 @@
 package airp_test
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	airp "github.com/d1ced/jsonparser_airp"
 )
@@ -33,3 +36,54 @@ func ExampleNode_Value() {
 	fmt.Println(v)
 	// Output: [map[a:<nil>] true]
 }
+
+func ExampleDecoder_Token() {
+	d := airp.NewDecoder(strings.NewReader(`{"a": [1, "two"]}`))
+	for {
+		tk, err := d.Token()
+		if err != nil {
+			break
+		}
+		fmt.Println(tk.Kind, tk.Value)
+	}
+	// Output:
+	// DelimKind {
+	// KeyKind a
+	// DelimKind [
+	// NumberKind 1
+	// StringKind two
+	// DelimKind ]
+	// DelimKind }
+}
+
+func ExampleNode_Encode() {
+	n, _ := airp.NewJSONString(`{"b": [1, 2], "a": "<tag>"}`)
+	n.Encode(os.Stdout, airp.EncodeOptions{
+		Indent:          "  ",
+		SpaceAfterColon: true,
+		SortKeys:        true,
+		Escape:          airp.EscapeHTML,
+		TrailingNewline: true,
+	})
+	// Output:
+	// {
+	//   "a": "\u003ctag\u003e",
+	//   "b": [
+	//     1,
+	//     2
+	//   ]
+	// }
+}
+
+func ExampleParseError_Snippet() {
+	_, err := airp.NewJSONString("{\n\t\"a\": [1, 2,, 3]\n}")
+	var pErr *airp.ParseError
+	if errors.As(err, &pErr) && errors.Is(err, airp.ErrUnexpectedToken) {
+		fmt.Println(pErr)
+		fmt.Println(pErr.Snippet())
+	}
+	// Output:
+	// 1:12: unexpected token ','; expected value (at a.2 in Array)
+	// 	"a": [1, 2,, 3]
+	// 	           ^
+}
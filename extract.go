@@ -0,0 +1,154 @@
+package airp
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Extract reads the JSON document from r and calls fn with the path and
+// the tree of each value that matches one of paths. The paths are written
+// like for GetChild; a "*" matches every key or index at its place, e.g.
+// "data.items.*.id". The empty path matches the whole document.
+// Only matching values are built as trees. They have no parent and the
+// values inside them are not matched again. Object keys are not checked
+// for uniqueness. Arrays and objects that cannot match are skipped without
+// reading their values, only their brackets, strings and comments are
+// checked. Limits but MaxDepth and MaxBytes do not apply to them.
+// An error of fn stops Extract which then returns it.
+func Extract(r io.Reader, paths []string, fn func(path string, n *Node) error) error {
+	return ParseOptions{}.Extract(r, paths, fn)
+}
+
+// Extract reads the JSON document from r and calls fn with the path and
+// the tree of each value that matches one of paths. See Extract.
+func (o ParseOptions) Extract(r io.Reader, paths []string, fn func(path string, n *Node) error) error {
+	patterns := make([][]string, len(paths))
+	for i, p := range paths {
+		if p != "" {
+			patterns[i] = strings.Split(p, ".")
+		}
+	}
+	d := o.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		n := len(d.stack) // frames in the path of the value
+		switch {
+		case t.Kind == KeyKind:
+			continue
+		case t.Kind == DelimKind && (t.Value == "]" || t.Value == "}"):
+			continue
+		case t.Kind == DelimKind:
+			n--
+		}
+		full, prefix := d.match(patterns, n)
+		switch {
+		case full:
+			path := d.pathTo(n)
+			m, err := d.node(t, nil)
+			if err != nil {
+				return err
+			}
+			if err := fn(path, m); err != nil {
+				return err
+			}
+		case !prefix && t.Kind == DelimKind:
+			if err := d.skipNested(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// match reports whether the path of the first n open arrays and objects
+// matches one of patterns fully or is the start of one.
+func (d *Decoder) match(patterns [][]string, n int) (full, prefix bool) {
+	for _, p := range patterns {
+		if len(p) < n {
+			continue
+		}
+		ok := true
+		for i, f := range d.stack[:n] {
+			switch {
+			case p[i] == "*":
+			case f.jsonType == Array:
+				ok = p[i] == strconv.Itoa(f.index)
+			default:
+				ok = p[i] == f.key
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok && len(p) == n {
+			return true, true
+		}
+		prefix = prefix || ok
+	}
+	return false, prefix
+}
+
+// node reads the value starting with t into a tree below parent.
+func (d *Decoder) node(t Token, parent *Node) (*Node, error) {
+	n := &Node{parent: parent}
+	n.span = Span{Start: d.prev.span[0], End: d.prev.span[1]}
+	switch t.Kind {
+	case StringKind:
+		n.jsonType, n.value = String, t.Value
+	case NumberKind:
+		n.jsonType, n.value = Number, d.number()
+	case BoolKind:
+		n.jsonType, n.value = Bool, t.Value == "true"
+	case NullKind:
+		n.jsonType = Null
+	case DelimKind:
+		var nn []*Node
+		var kn []KeyNode
+		for {
+			c, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if c.Kind == DelimKind && (c.Value == "]" || c.Value == "}") {
+				break
+			}
+			var keySpan Span
+			if c.Kind == KeyKind {
+				keySpan = Span{Start: d.prev.span[0], End: d.prev.span[1]}
+				kn = append(kn, KeyNode{Key: c.Value})
+				if c, err = d.Token(); err != nil {
+					return nil, err
+				}
+			}
+			m, err := d.node(c, n)
+			if err != nil {
+				return nil, err
+			}
+			if t.Value == "[" {
+				nn = append(nn, m)
+			} else {
+				m.keySpan = keySpan
+				kn[len(kn)-1].Node = m
+			}
+		}
+		if t.Value == "[" {
+			n.jsonType, n.value = Array, nn
+		} else {
+			n.jsonType, n.value = Object, kn
+		}
+		n.span.End = d.prev.span[1]
+	}
+	return n, nil
+}
+
+// number returns the value of the number token read last like the parser
+// does.
+func (d *Decoder) number() interface{} {
+	v, _ := parseNumber(d.prev.value, d.opts.Syntax)
+	return v
+}
@@ -0,0 +1,78 @@
+package airp
+
+import (
+	"fmt"
+	"io"
+)
+
+// ElementError is an error in an element of the array read by an
+// ArrayIterator.
+type ElementError struct {
+	Index int   // index of the element
+	Err   error // usually a *ParseError
+}
+
+func (e *ElementError) Error() string {
+	return fmt.Sprintf("element %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the error of the element.
+func (e *ElementError) Unwrap() error {
+	return e.Err
+}
+
+// ArrayIterator reads the elements of a top-level array one at a time
+// so that only one element has to be held in memory.
+type ArrayIterator struct {
+	d       *Decoder
+	started bool // the opening bracket was read
+	index   int  // of the next element
+	err     error
+}
+
+// NewArrayIterator creates an ArrayIterator reading from r.
+func NewArrayIterator(r io.Reader) *ArrayIterator {
+	return ParseOptions{}.NewArrayIterator(r)
+}
+
+// NewArrayIterator creates an ArrayIterator reading from r.
+func (o ParseOptions) NewArrayIterator(r io.Reader) *ArrayIterator {
+	return &ArrayIterator{d: o.NewDecoder(r)}
+}
+
+// Next returns the next element of the array as a tree without parent.
+// After the last one and the end of the input it returns io.EOF. Errors in
+// the elements are of type *ElementError. Input that is no array or does
+// not end after it is a *ParseError. After an error Next returns it again.
+func (it *ArrayIterator) Next() (*Node, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if !it.started {
+		t, err := it.d.Token()
+		if err == nil && (t.Kind != DelimKind || t.Value != "[") {
+			err = it.d.fail("array", it.d.prev)
+		}
+		if err != nil {
+			it.err = err
+			return nil, err
+		}
+		it.started = true
+	}
+	t, err := it.d.Token()
+	if err != nil {
+		it.err = &ElementError{Index: it.index, Err: err}
+		return nil, it.err
+	}
+	if t.Kind == DelimKind && t.Value == "]" {
+		_, it.err = it.d.Token() // io.EOF or input after the array
+		return nil, it.err
+	}
+	n, err := it.d.node(t, nil)
+	if err != nil {
+		it.err = &ElementError{Index: it.index, Err: err}
+		return nil, it.err
+	}
+	it.index++
+	return n, nil
+}
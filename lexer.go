@@ -1,249 +1,865 @@
 package airp
 
 import (
-	"bufio"
-	"bytes"
-	"fmt"
 	"io"
-	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
+// eof is returned by read when the input is exhausted.
+const eof rune = -1
+
+// minRead is the minimum number of bytes requested from a reader at once.
+const minRead = 4096
+
+// excerptWidth is the maximum number of bytes of the input shown before and
+// after an error.
+const excerptWidth = 64
+
 // lexer gnereates tokens from json
-// after sending an error token the lexer has to quit
+// after emitting an error token the lexer has to quit unless resume is set
 type lexer struct {
 	mode     lexFunc
-	reader   *bufio.Reader
-	buf      *bytes.Buffer
-	out      chan<- token
-	quit     <-chan struct{}
+	reader   io.Reader // source of more data; nil if data holds everything
+	data     []byte    // window of the input that is not yet consumed
+	base     int       // offset of data in the input
+	start    int       // start of the current token in data
+	pos      int       // read position in data
+	width    int       // width of the last rune read
+	buf      []byte    // scratch space for decoded strings
+	tok      token
+	ready    bool
 	row, col int
+	tokPos   Position // position of the current token
+	err      error    // kind of the error token
+	readErr  error    // error of reader other than io.EOF
+
+	surrogates SurrogatePolicy
+	json5      bool // accept JSON5
+	comments   bool // accept comments
+	trivia     bool // emit comments as tokens
+	resume     bool // continue after error tokens
+	repair     bool // fix malformed input
+	fixes      []Fix
+
+	more    bool    // data may be continued by push
+	starved bool    // a mode read beyond the data pushed so far
+	waiting bool    // a mode stopped at the end of the data pushed so far
+	ahead   []token // tokens lexed by available
+
+	maxBytes  int      // limit of the input; 0 means none
+	maxString int      // limit of the length of strings; 0 means none
+	maxNumber int      // limit of the length of numbers; 0 means none
+	truncated bool     // data is cut off at maxBytes
+	over      bool     // read reached maxBytes of truncated data
+	overPos   Position // position of maxBytes
 }
 
 type lexFunc func(*lexer) lexFunc
 
-func lexSend(l *lexer, f lexFunc, t token) lexFunc {
-	select {
-	case <-l.quit:
-		return nil
-	case l.out <- t:
-		return f
+// lex creates a lexer reading json from data.
+func lex(data io.Reader) *lexer {
+	return &lexer{
+		mode:   noneMode,
+		reader: data,
+		data:   make([]byte, 0, minRead+excerptWidth),
 	}
 }
 
-// lex reads in a json string and generate tokens for the parser.
-func lex(data io.Reader) (stream <-chan token, quit func()) {
-	ch, q := make(chan token, 1), make(chan struct{})
-	l := &lexer{
-		mode:   noneMode,
-		reader: bufio.NewReader(data),
-		buf:    new(bytes.Buffer),
-		out:    ch,
-		quit:   q,
+// lexBytes creates a lexer reading json directly from data.
+// data is not modified.
+func lexBytes(data []byte) *lexer {
+	return &lexer{
+		mode: noneMode,
+		data: data,
+	}
+}
+
+// lexPush creates a lexer reading json passed to push.
+func lexPush() *lexer {
+	return &lexer{
+		mode: noneMode,
+		data: make([]byte, 0, minRead+excerptWidth),
+		more: true,
 	}
-	go func() {
-		for f := l.mode; f != nil; f = f(l) {
+}
+
+// next runs the state machine until a token is available and returns it.
+// ok is false if the input is exhausted or an error token was returned
+// before and resume is not set.
+func (l *lexer) next() (t token, ok bool) {
+	if len(l.ahead) > 0 {
+		t, l.ahead = l.ahead[0], l.ahead[1:]
+		return t, true
+	}
+	for !l.ready {
+		if l.mode == nil {
+			l.err = nil
+			end := Position{Line: l.row, Column: l.col, Offset: l.base + l.pos}
+			return token{position: [2]int{l.row, l.col}, span: [2]Position{end, end}}, false
 		}
-		close(l.out)
-	}()
-	return ch, func() { close(q) }
+		l.step()
+	}
+	l.ready = false
+	return l.tok, true
 }
 
-func noneMode(l *lexer) lexFunc {
-start:
-	r, _, err := l.reader.ReadRune()
-	if err != nil {
-		return nil
+// step runs the current mode once. It reports false if the mode ran out of
+// the data pushed so far. The lexer returns to the start of the token then
+// to run again with more data unless the mode is waiting to continue.
+func (l *lexer) step() bool {
+	l.mode = l.mode(l)
+	if l.waiting {
+		l.waiting = false
+		return false
 	}
-	switch r {
-	case '\n':
-		l.row++
-		l.col = 0
-		goto start
-	case '\r':
-		l.col = 0
-		goto start
-	case ' ', '\t':
-		l.col++
-		goto start
-	case '{', '}', '[', ']', ',', ':':
-		m := lexSend(l, noneMode, newToken(r, l.row, l.col))
-		l.col++
-		return m
-	case '"':
-		return stringMode
-	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		l.reader.UnreadRune()
-		return numberMode
-	default:
-		l.reader.UnreadRune()
-		return otherMode
+	if l.starved {
+		l.starved, l.ready, l.err = false, false, nil
+		l.pos, l.width = l.start, 0
+		l.row, l.col = l.tokPos.Line, l.tokPos.Column
+		l.mode = noneMode
+		return false
 	}
+	if l.over {
+		// replaces the token cut off at maxBytes
+		l.over, l.truncated = false, false
+		l.tokPos = l.overPos
+		l.err = ErrInputTooLarge
+		l.emit(token{})
+		l.mode = nil
+	}
+	return true
 }
 
-func stringMode(l *lexer) lexFunc {
-start:
-	r, _, err := l.reader.ReadRune()
-	if err != nil {
-		lexSend(l, nil, token{
-			value:    `"` + l.buf.String(),
-			position: [2]int{l.row, l.col},
-		})
-		return nil
+// available reports whether next can return a token other than a comment
+// or the end of the input with the data pushed so far. The tokens are
+// lexed ahead.
+func (l *lexer) available() bool {
+	for {
+		if n := len(l.ahead); n > 0 && l.ahead[n-1].Type != commentToken || l.mode == nil {
+			return true
+		}
+		for !l.ready && l.mode != nil {
+			if !l.step() {
+				return false
+			}
+		}
+		if l.ready {
+			l.ready = false
+			l.ahead = append(l.ahead, l.tok)
+		}
+	}
+}
+
+// push appends chunk to the input of a lexer created by lexPush. A nil
+// chunk ends the input.
+func (l *lexer) push(chunk []byte) {
+	if chunk == nil {
+		l.more = false
+		return
+	}
+	l.compact()
+	l.data = append(l.data, chunk...)
+	if over := l.base + len(l.data) - l.maxBytes; l.maxBytes > 0 && over > 0 {
+		l.data = l.data[:len(l.data)-over]
+		l.truncated = true
+		l.more = false
+	}
+}
+
+// compact drops the data before the current token but up to excerptWidth
+// bytes for error messages.
+func (l *lexer) compact() {
+	if keep := l.start - excerptWidth; keep > 0 {
+		n := copy(l.data, l.data[keep:])
+		l.data = l.data[:n]
+		l.pos -= keep
+		l.base += keep
+		l.start -= keep
+	}
+}
+
+func (l *lexer) emit(t token) {
+	t.position = [2]int{l.tokPos.Line, l.tokPos.Column}
+	t.span = [2]Position{l.tokPos, {Line: l.row, Column: l.col, Offset: l.base + l.pos}}
+	l.tok, l.ready = t, true
+}
+
+// fill reads more input into data keeping everything from start on and up
+// to excerptWidth bytes before for error messages.
+// It reports whether new data is available.
+func (l *lexer) fill() bool {
+	if l.reader == nil {
+		return false
+	}
+	l.compact()
+	if cap(l.data)-len(l.data) < minRead {
+		data := make([]byte, len(l.data), 2*cap(l.data)+minRead)
+		copy(data, l.data)
+		l.data = data
 	}
-	if r == '\\' {
-		err := escape(l)
+	for {
+		n, err := l.reader.Read(l.data[len(l.data):cap(l.data)])
+		l.data = l.data[:len(l.data)+n]
+		if over := l.base + len(l.data) - l.maxBytes; l.maxBytes > 0 && over > 0 {
+			l.data = l.data[:len(l.data)-over]
+			l.truncated = true
+			l.reader = nil
+			return n > over
+		}
 		if err != nil {
-			lexSend(l, nil, token{
-				value: l.buf.String(),
-				position: [2]int{
-					l.row,
-					l.col - utf8.RuneCount(l.buf.Bytes()) - 1,
-				},
-			})
+			if err != io.EOF {
+				l.readErr = err
+			}
+			l.reader = nil
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
+}
+
+// read returns the next rune of the input or eof.
+func (l *lexer) read() rune {
+	if l.pos >= len(l.data) && !l.fill() {
+		l.width = 0
+		l.starved = l.more
+		if l.truncated && !l.over {
+			l.over = true
+			l.overPos = Position{Line: l.row, Column: l.col, Offset: l.base + l.pos}
+		}
+		return eof
+	}
+	if r := rune(l.data[l.pos]); r < utf8.RuneSelf {
+		l.pos++
+		l.width = 1
+		l.col++
+		return r
+	}
+	if !utf8.FullRune(l.data[l.pos:]) && !l.fill() && l.more {
+		l.width = 0
+		l.starved = true
+		return eof
+	}
+	r, w := utf8.DecodeRune(l.data[l.pos:])
+	l.pos += w
+	l.width = w
+	l.col++
+	return r
+}
+
+// backup steps back the last rune read. It can only be called once per
+// call of read.
+func (l *lexer) backup() {
+	if l.width > 0 {
+		l.pos -= l.width
+		l.width = 0
+		l.col--
+	}
+}
+
+// mark returns the read position relative to the current token. It stays
+// valid when fill moves the data.
+func (l *lexer) mark() (pos, col int) {
+	return l.pos - l.start, l.col
+}
+
+// reset returns to a position taken by mark.
+func (l *lexer) reset(pos, col int) {
+	l.pos, l.col = l.start+pos, col
+	l.width = 0
+}
+
+// accept consumes s if the input continues with it.
+func (l *lexer) accept(s string) bool {
+	pos, col := l.mark()
+	for _, c := range s {
+		if l.read() != c {
+			l.reset(pos, col)
+			return false
+		}
+	}
+	return true
+}
+
+// excerpt returns the line of the input around p as far as it is still in
+// data and at most excerptWidth bytes before and after p. col is the column
+// of p in line.
+func (l *lexer) excerpt(p Position) (line string, col int) {
+	i := p.Offset - l.base
+	if i < 0 || i > len(l.data) {
+		return "", 0
+	}
+	start := i
+	for start > 0 && i-start < excerptWidth && !isLineBreak(l.data[start-1]) {
+		start--
+	}
+	for start < i && !utf8.RuneStart(l.data[start]) {
+		start++
+	}
+	end := i
+	for end < len(l.data) && end-i < excerptWidth && !isLineBreak(l.data[end]) {
+		end++
+	}
+	for end > i && end < len(l.data) && !utf8.RuneStart(l.data[end]) {
+		end--
+	}
+	return string(l.data[start:end]), utf8.RuneCount(l.data[start:i])
+}
+
+func isLineBreak(b byte) bool {
+	return b == '\n' || b == '\r'
+}
+
+// current returns the text of the current token.
+func (l *lexer) current() string {
+	return string(l.data[l.start:l.pos])
+}
+
+func noneMode(l *lexer) lexFunc {
+	for {
+		l.start = l.pos
+		l.tokPos = Position{Line: l.row, Column: l.col, Offset: l.base + l.pos}
+		r := l.read()
+		switch r {
+		case eof:
 			return nil
+		case '\n':
+			l.row++
+			l.col = 0
+		case '\r':
+			l.col = 0
+		case ' ', '\t':
+		case '{', '}', '[', ']', ',', ':':
+			l.emit(newToken(r, l.tokPos.Line, l.tokPos.Column))
+			return noneMode
+		case '"':
+			return stringMode
+		case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			l.backup()
+			return numberMode
+		case '/':
+			if l.comments {
+				return commentMode
+			}
+			l.backup()
+			return otherMode
+		case '\'', '+', '.':
+			switch {
+			case r == '\'' && (l.json5 || l.repair):
+				l.fix(FixSingleQuotes)
+				return stringMode
+			case l.json5:
+				l.backup()
+				return numberMode
+			}
+			l.backup()
+			return otherMode
+		default:
+			if l.json5 && isSpace5(r) {
+				continue
+			}
+			l.backup()
+			return otherMode
 		}
-		goto start
 	}
-	if r == '"' {
-		m := lexSend(l, noneMode, token{
-			Type:     stringToken,
-			value:    l.buf.String(),
-			position: [2]int{l.row, l.col},
-		})
-		l.col += utf8.RuneCount(l.buf.Bytes()) + 2
-		l.buf.Reset()
-		return m
+}
+
+// commentMode reads a comment of JSONC or JSON5. The leading slash is
+// already read. The comment is only emitted if trivia is requested.
+func commentMode(l *lexer) lexFunc {
+	switch l.read() {
+	case '/':
+		for {
+			switch l.read() {
+			case '\n', '\r':
+				l.backup()
+				return l.emitComment()
+			case eof:
+				return l.emitComment()
+			}
+		}
+	case '*':
+		for prev := rune(0); ; {
+			r := l.read()
+			switch r {
+			case eof:
+				return l.fail(ErrUnexpectedEOF)
+			case '\n':
+				l.row++
+				l.col = 0
+			case '\r':
+				l.col = 0
+			case '/':
+				if prev == '*' {
+					return l.emitComment()
+				}
+			}
+			prev = r
+		}
+	default:
+		return l.fail(ErrUnexpectedToken)
 	}
-	l.buf.WriteRune(r)
-	goto start
 }
 
-func otherMode(l *lexer) lexFunc {
-	var err error
-	var r rune
-	for i := 0; i < 4; i++ {
-		r, _, err = l.reader.ReadRune()
-		if err != nil {
-			goto errL
-		}
-		l.buf.WriteRune(r)
-	}
-	if l.buf.String() == "null" {
-		m := lexSend(l, noneMode, token{
-			Type:     nullToken,
-			position: [2]int{l.row, l.col},
-		})
-		l.col += len("null")
-		l.buf.Reset()
-		return m
-	}
-	if l.buf.String() == "true" {
-		m := lexSend(l, noneMode, token{
-			Type:     trueToken,
-			position: [2]int{l.row, l.col},
-		})
-		l.col += len("true")
-		l.buf.Reset()
-		return m
-	}
-	r, _, err = l.reader.ReadRune()
-	if err != nil {
-		goto errL
-	}
-	l.buf.WriteRune(r)
-	if l.buf.String() == "false" {
-		m := lexSend(l, noneMode, token{
-			Type:     falseToken,
-			position: [2]int{l.row, l.col},
-		})
-		l.col += len("false")
-		l.buf.Reset()
-		return m
-	}
-errL:
-	for i, r := range l.buf.String() {
+func stringMode(l *lexer) lexFunc {
+	l.buf = l.buf[:0]
+	return stringRest(l, false)
+}
+
+// stringRest reads the rest of a string. escaped reports whether the string
+// is decoded into buf. A string cut off by the end of the data pushed so far
+// continues where it stopped once there is more.
+func stringRest(l *lexer, escaped bool) lexFunc {
+	quote := rune(l.data[l.start]) // JSON5 allows single quotes
+	for {
+		rPos, rCol := l.mark() // of r to continue with more data
+		row, n, wasEscaped := l.row, len(l.buf), escaped
+		r := l.read()
 		switch r {
-		case ' ', '\t', '\r', '\n', '{', '[', ']', '}', ',', ':':
-			lexSend(l, nil, token{
-				value:    l.buf.String()[:i],
-				position: [2]int{l.row, l.col},
-			})
+		case eof:
+			if l.starved {
+				return l.waitString(rPos, rCol, row, n, wasEscaped)
+			}
+			if l.repair {
+				return l.closeString(escaped)
+			}
+			return l.fail(ErrUnexpectedEOF)
+		case '\\':
+			if !escaped {
+				escaped = true
+				l.buf = append(l.buf, l.data[l.start+1:l.pos-1]...)
+			}
+			pos, col := l.mark()
+			err := escape(l)
+			if l.starved {
+				return l.waitString(rPos, rCol, row, n, wasEscaped)
+			}
+			if err != nil {
+				if err == ErrUnexpectedEOF && l.repair {
+					return l.closeString(true)
+				}
+				l.tokPos = Position{Line: l.row, Column: col - 1, Offset: l.base + l.start + pos - 1}
+				l.err = err
+				l.emit(token{value: string(l.data[l.start+pos-1 : l.pos])})
+				if l.resume {
+					l.backup() // the rune after the escape may end the string
+					return skipMode
+				}
+				return nil
+			}
+			if l.tooLong(l.maxString, 1) {
+				return l.exceeded(ErrStringTooLong, pos-1, col-1)
+			}
+		case quote:
+			if escaped {
+				l.emit(token{Type: stringToken, value: string(l.buf)})
+			} else {
+				l.emit(token{
+					Type:  stringToken,
+					value: string(l.data[l.start+1 : l.pos-1]),
+				})
+			}
+			return noneMode
+		default:
+			if escaped {
+				l.buf = append(l.buf, l.data[l.pos-l.width:l.pos]...)
+			}
+			if l.tooLong(l.maxString, 1) {
+				return l.exceeded(ErrStringTooLong, l.pos-l.width-l.start, l.col-1)
+			}
+		}
+	}
+}
+
+// waitString returns to the rune at pos, col and row of a string decoded
+// into the first n bytes of buf that is cut off by the end of the data
+// pushed so far. The returned mode continues there.
+func (l *lexer) waitString(pos, col, row, n int, escaped bool) lexFunc {
+	l.reset(pos, col)
+	l.row, l.buf = row, l.buf[:n]
+	l.starved, l.waiting = false, true
+	return func(l *lexer) lexFunc { return stringRest(l, escaped) }
+}
+
+// closeString emits the string that is cut off by the end of the input.
+// The incomplete escape sequence at its end is dropped.
+func (l *lexer) closeString(escaped bool) lexFunc {
+	l.fix(FixCloseString)
+	if escaped {
+		l.emit(token{Type: stringToken, value: string(l.buf)})
+	} else {
+		l.emit(token{Type: stringToken, value: string(l.data[l.start+1 : l.pos])})
+	}
+	return nil
+}
+
+// completeLiteral returns the literal starting with the word cut off by the
+// end of the input or word itself if there is none.
+func (l *lexer) completeLiteral(word string) string {
+	for _, lit := range []string{"null", "true", "false"} {
+		if word != "" && len(word) < len(lit) && lit[:len(word)] == word {
+			l.fix(FixCompleteLiteral)
+			return lit
+		}
+	}
+	return word
+}
+
+// closeNumber emits the number that is cut off by the end of the input.
+// An incomplete sign, fraction or exponent at its end is dropped. A number
+// of nothing but a sign is dropped as a whole.
+func (l *lexer) closeNumber() lexFunc {
+	s := l.current()
+	for s != "" && strings.IndexByte("+-.eE", s[len(s)-1]) >= 0 {
+		s = s[:len(s)-1]
+	}
+	if s == "" {
+		return nil
+	}
+	if len(s) < l.pos-l.start {
+		l.fix(FixCompleteNumber)
+	}
+	l.emit(token{Type: numberToken, value: s})
+	return nil
+}
+
+// fix records a fix of the current token if the lexer repairs its input.
+func (l *lexer) fix(k FixKind) {
+	if l.repair {
+		l.fixes = append(l.fixes, Fix{Kind: k, Pos: l.tokPos})
+	}
+}
+
+// lineMode skips the rest of the line. A StreamDecoder continues with it
+// after a bad value.
+func lineMode(l *lexer) lexFunc {
+	for {
+		switch l.read() {
+		case eof:
 			return nil
+		case '\n':
+			l.row++
+			l.col = 0
+			return noneMode
+		}
+	}
+}
+
+// nestedMode returns a mode that skips the rest of an array or object up to
+// its closing bracket closer and emits that. The values in it are not
+// lexed, only brackets, strings and comments are read. maxDepth bounds the
+// nesting of the skipped part including the array or object itself.
+func nestedMode(closer rune, maxDepth int) lexFunc {
+	closers := []rune{closer}
+	return func(l *lexer) lexFunc {
+		for {
+			l.start = l.pos
+			l.tokPos = Position{Line: l.row, Column: l.col, Offset: l.base + l.pos}
+			switch r := l.read(); r {
+			case eof:
+				return l.fail(ErrUnexpectedEOF)
+			case '\n':
+				l.row++
+				l.col = 0
+			case '\r':
+				l.col = 0
+			case '[', '{':
+				if len(closers) == maxDepth {
+					return l.exceeded(ErrDepthExceeded, 0, l.tokPos.Column)
+				}
+				closers = append(closers, r+2) // two after in ASCII
+			case ']', '}':
+				if r != closers[len(closers)-1] {
+					return l.fail(ErrUnexpectedToken)
+				}
+				if closers = closers[:len(closers)-1]; len(closers) == 0 {
+					l.emit(newToken(r, l.tokPos.Line, l.tokPos.Column))
+					return noneMode
+				}
+			case '"', '\'':
+				if r == '\'' && !l.json5 {
+					continue
+				}
+				if skipMode(l) == nil {
+					return l.fail(ErrUnexpectedEOF)
+				}
+			case '/':
+				if !l.comments {
+					continue
+				}
+				if next := commentMode(l); l.ready || next == nil {
+					return next // error in the comment
+				}
+			}
 		}
 	}
+}
+
+// skipMode reads the rest of a string with an invalid escape sequence.
+func skipMode(l *lexer) lexFunc {
+	quote := rune(l.data[l.start])
 	for {
-		r, _, err := l.reader.ReadRune()
-		if err != nil {
-			lexSend(l, nil, token{
-				value:    l.buf.String(),
-				position: [2]int{l.row, l.col},
-			})
+		switch l.read() {
+		case eof:
 			return nil
+		case '\\':
+			if l.read() == '\n' {
+				l.row++
+				l.col = 0
+			}
+		case quote:
+			return noneMode
 		}
-		switch r {
+	}
+}
+
+func otherMode(l *lexer) lexFunc {
+	if l.json5 || l.repair {
+		r := l.read()
+		for first := true; isIdentRune(r, first); first = false {
+			r = l.read()
+		}
+		l.backup()
+		word := l.current()
+		if r == eof && l.repair {
+			word = l.completeLiteral(word)
+		}
+		switch word {
+		case "":
+			// no identifier; reported below
+		case "null":
+			l.emit(token{Type: nullToken, value: word})
+			return noneMode
+		case "true":
+			l.emit(token{Type: trueToken, value: word})
+			return noneMode
+		case "false":
+			l.emit(token{Type: falseToken, value: word})
+			return noneMode
+		default:
+			l.emit(token{Type: identToken, value: word})
+			return noneMode
+		}
+	}
+	switch {
+	case l.accept("null"):
+		l.emit(token{Type: nullToken})
+		return noneMode
+	case l.accept("true"):
+		l.emit(token{Type: trueToken})
+		return noneMode
+	case l.accept("false"):
+		l.emit(token{Type: falseToken})
+		return noneMode
+	}
+	for {
+		switch l.read() {
 		case ' ', '\t', '\r', '\n', '{', '}', '[', ']', ',', ':':
-			lexSend(l, nil, token{
-				value:    l.buf.String(),
-				position: [2]int{l.row, l.col},
-			})
-			return nil
+			l.backup()
+			fallthrough
+		case eof:
+			return l.fail(ErrUnexpectedToken)
 		}
-		l.buf.WriteRune(r)
 	}
 }
 
 func numberMode(l *lexer) lexFunc {
-start:
-	r, _, err := l.reader.ReadRune()
-	if err != nil {
-		lexSend(l, nil, token{
-			Type:     numberToken,
-			value:    l.buf.String(),
-			position: [2]int{l.row, l.col},
-		})
-		return nil
+	for {
+		switch r := l.read(); r {
+		case '-', '+', 'e', 'E', '.', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			if l.tooLong(l.maxNumber, 0) {
+				return l.exceeded(ErrNumberTooLong, l.pos-l.width-l.start, l.col-1)
+			}
+		case eof:
+			if l.repair {
+				return l.closeNumber()
+			}
+			l.emit(token{Type: numberToken, value: l.current()})
+			return nil
+		default:
+			if l.json5 && isIdentRune(r, false) {
+				if l.tooLong(l.maxNumber, 0) {
+					return l.exceeded(ErrNumberTooLong, l.pos-l.width-l.start, l.col-1)
+				}
+				continue // hexadecimal numbers, Infinity and NaN
+			}
+			l.backup()
+			l.emit(token{Type: numberToken, value: l.current()})
+			return noneMode
+		}
 	}
-	switch r {
-	case '-', '+', 'e', 'E', '.', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		l.buf.WriteRune(r)
-		goto start
-	default:
-		l.reader.UnreadRune()
-		lexSend(l, noneMode, token{
-			Type:     numberToken,
-			value:    l.buf.String(),
-			position: [2]int{l.row, l.col},
-		})
-		l.col += utf8.RuneCount(l.buf.Bytes())
-		l.buf.Reset()
+}
+
+// tooLong reports whether the current token without the first skip bytes
+// is longer than max bytes. A max of 0 means no limit.
+func (l *lexer) tooLong(max, skip int) bool {
+	return max > 0 && l.pos-l.start-skip > max
+}
+
+// exceeded emits an error token of kind err at pos and col relative to the
+// current token. The lexer stops after it.
+func (l *lexer) exceeded(err error, pos, col int) lexFunc {
+	l.tokPos = Position{Line: l.row, Column: col, Offset: l.base + l.start + pos}
+	l.err = err
+	l.emit(token{})
+	return nil
+}
+
+// fail emits the current token as error token of kind err. The lexer
+// stops after it.
+func (l *lexer) fail(err error) lexFunc {
+	l.err = err
+	l.emit(token{value: l.current()})
+	if l.resume {
 		return noneMode
 	}
+	return nil
 }
 
-func escape(l *lexer) error {
-	r, _, err := l.reader.ReadRune()
-	if err != nil {
-		return err
+func (l *lexer) emitComment() lexFunc {
+	if l.trivia {
+		l.emit(token{Type: commentToken, value: l.current()})
 	}
+	return noneMode
+}
+
+// escape decodes the escape sequence following a backslash into buf.
+// It returns ErrInvalidEscape for a malformed sequence and
+// ErrUnexpectedEOF if the input ends within it.
+func escape(l *lexer) error {
+	r := l.read()
 	switch r {
-	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
-		l.buf.WriteRune('\\')
-		l.buf.WriteRune(r)
-		return nil
+	case '"', '\\', '/':
+		l.buf = append(l.buf, byte(r))
+	case 'b':
+		l.buf = append(l.buf, '\b')
+	case 'f':
+		l.buf = append(l.buf, '\f')
+	case 'n':
+		l.buf = append(l.buf, '\n')
+	case 'r':
+		l.buf = append(l.buf, '\r')
+	case 't':
+		l.buf = append(l.buf, '\t')
 	case 'u':
-		b := make([]byte, 4)
-		n, err := l.reader.Read(b)
-		if err != nil || n != 4 {
-			return fmt.Errorf("malformed escape sequence '\\%s'", string(b))
+		r, err := hex(l, 4)
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) && r < 0xdc00 {
+			// a high surrogate has to be followed by a low one
+			pos, col := l.mark()
+			if l.accept(`\u`) {
+				if lo, err := hex(l, 4); err == nil && utf16.IsSurrogate(lo) && lo >= 0xdc00 {
+					l.buf = utf8.AppendRune(l.buf, utf16.DecodeRune(r, lo))
+					return nil
+				}
+				l.reset(pos, col)
+			}
+		}
+		if utf16.IsSurrogate(r) {
+			if l.surrogates == SurrogateError {
+				return ErrInvalidEscape
+			}
+			r = utf8.RuneError
+		}
+		l.buf = utf8.AppendRune(l.buf, r)
+	case eof:
+		return ErrUnexpectedEOF
+	default:
+		if r == '\'' && l.repair && l.data[l.start] == '\'' {
+			l.buf = append(l.buf, '\'') // in single quotes
+			return nil
+		}
+		if !l.json5 {
+			return ErrInvalidEscape
+		}
+		return escape5(l, r)
+	}
+	return nil
+}
+
+// escape5 decodes the escape sequences JSON5 adds to JSON. A backslash at
+// the end of a line continues the string on the next line.
+func escape5(l *lexer, r rune) error {
+	switch r {
+	case 'v':
+		l.buf = append(l.buf, '\v')
+	case '0':
+		if c := l.read(); '0' <= c && c <= '9' {
+			return ErrInvalidEscape
 		}
-		i, err := strconv.ParseInt(string(b), 16, 32)
+		l.backup()
+		l.buf = append(l.buf, 0)
+	case 'x':
+		r, err := hex(l, 2)
 		if err != nil {
-			return fmt.Errorf("malformed escape sequence '\\%s'", string(b))
+			return err
 		}
-		l.buf.WriteRune(rune(i))
-		return nil
+		l.buf = utf8.AppendRune(l.buf, r)
+	case '\n':
+		l.row++
+		l.col = 0
+	case '\r':
+		if l.accept("\n") {
+			l.row++
+		}
+		l.col = 0
+	case '\u2028', '\u2029':
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return ErrInvalidEscape
+	default:
+		l.buf = utf8.AppendRune(l.buf, r)
+	}
+	return nil
+}
+
+// hex reads the n hex digits of an escape sequence.
+func hex(l *lexer, n int) (rune, error) {
+	var r rune
+	for i := 0; i < n; i++ {
+		c := l.read()
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		case c == eof:
+			return 0, ErrUnexpectedEOF
+		default:
+			return 0, ErrInvalidEscape
+		}
+		r = r<<4 | c
+	}
+	return r, nil
+}
+
+// isIdentRune reports whether r may be part of an ECMAScript identifier
+// name as used by JSON5. Digits and marks are not allowed as first rune.
+func isIdentRune(r rune, first bool) bool {
+	switch {
+	case r == '$', r == '_', unicode.IsLetter(r), unicode.Is(unicode.Nl, r):
+		return true
+	case first:
+		return false
 	default:
-		return fmt.Errorf("malformed escape sequence")
+		return unicode.In(r, unicode.Nd, unicode.Mn, unicode.Mc, unicode.Pc) ||
+			r == '\u200c' || r == '\u200d'
+	}
+}
+
+// isSpace5 reports whether r is white space in JSON5 besides the white
+// space of JSON.
+func isSpace5(r rune) bool {
+	switch r {
+	case '\v', '\f', '\u00a0', '\ufeff', '\u2028', '\u2029':
+		return true
 	}
+	return unicode.Is(unicode.Zs, r)
 }
@@ -0,0 +1,180 @@
+package airp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// JSONNumber is the value of a Number node. It holds the number as it was
+// written in JSON so that no precision is lost.
+type JSONNumber string
+
+// String returns the literal text of the number.
+func (n JSONNumber) String() string {
+	return string(n)
+}
+
+// Int64 returns the number as an int64.
+func (n JSONNumber) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// Uint64 returns the number as an uint64.
+func (n JSONNumber) Uint64() (uint64, error) {
+	return strconv.ParseUint(string(n), 10, 64)
+}
+
+// Float64 returns the nearest float64 of the number.
+func (n JSONNumber) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// BigInt returns the number as a big.Int. Numbers with a fraction or an
+// exponent are accepted as long as their value is integral.
+func (n JSONNumber) BigInt() (*big.Int, error) {
+	if i, ok := new(big.Int).SetString(string(n), 10); ok {
+		return i, nil
+	}
+	r, ok := new(big.Rat).SetString(string(n))
+	if !ok || !validNumber(string(n)) {
+		return nil, fmt.Errorf("invalid number %q", string(n))
+	}
+	if !r.IsInt() {
+		return nil, fmt.Errorf("number %s is not an integer", string(n))
+	}
+	return r.Num(), nil
+}
+
+// BigFloat returns the number as a big.Float with enough precision to hold
+// every digit of n.
+func (n JSONNumber) BigFloat() (*big.Float, error) {
+	prec := uint(len(n)) * 4
+	if prec < 64 {
+		prec = 64
+	}
+	f, _, err := big.ParseFloat(string(n), 10, prec, big.ToNearestEven)
+	return f, err
+}
+
+// numberOf returns the value of a Number node as JSONNumber.
+// Number nodes may also hold a float64.
+func numberOf(v interface{}) JSONNumber {
+	switch v := v.(type) {
+	case JSONNumber:
+		return v
+	case float64:
+		return JSONNumber(strconv.FormatFloat(v, 'g', -1, 64))
+	default:
+		panic(fmt.Errorf("invariant violation: number of type %T", v))
+	}
+}
+
+// numberEq compares two values of Number nodes by their exact value.
+func numberEq(a, b interface{}) bool {
+	x, y := numberOf(a), numberOf(b)
+	if x == y {
+		return true
+	}
+	rx, ok := new(big.Rat).SetString(string(x))
+	if !ok {
+		return false
+	}
+	ry, ok := new(big.Rat).SetString(string(y))
+	return ok && rx.Cmp(ry) == 0
+}
+
+// validNumber reports whether s is a number as defined by RFC 8259:
+//
+//	number = [ minus ] int [ frac ] [ exp ]
+func validNumber(s string) bool {
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && '1' <= s[i] && s[i] <= '9':
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	default:
+		return false
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		if i >= len(s) || !isDigit(s[i]) {
+			return false
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+		if i >= len(s) || !isDigit(s[i]) {
+			return false
+		}
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+	return i == len(s)
+}
+
+// parseNumber converts the text of a number token into the value of a
+// Number node. It reports whether s is a number of syntax.
+func parseNumber(s string, syntax Syntax) (interface{}, bool) {
+	if syntax == SyntaxJSON5 {
+		return json5Number(s)
+	}
+	return JSONNumber(s), validNumber(s)
+}
+
+// json5Number converts a JSON5 number to JSONNumber. Infinity and NaN are
+// returned as float64.
+func json5Number(s string) (interface{}, bool) {
+	sign := ""
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		if s[0] == '-' {
+			sign = "-"
+		}
+		s = s[1:]
+	}
+	switch {
+	case s == "Infinity" && sign == "":
+		return math.Inf(1), true
+	case s == "Infinity":
+		return math.Inf(-1), true
+	case s == "NaN":
+		return math.NaN(), true
+	case len(s) > 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X'):
+		if s[2] == '+' || s[2] == '-' {
+			return nil, false
+		}
+		i, ok := new(big.Int).SetString(s[2:], 16)
+		if !ok {
+			return nil, false
+		}
+		return JSONNumber(sign + i.String()), true
+	}
+	// JSON5 allows a decimal point without digits on one side
+	if strings.HasPrefix(s, ".") {
+		s = "0" + s
+	} else if i := strings.IndexByte(s, '.'); i >= 0 &&
+		(i == len(s)-1 || s[i+1] == 'e' || s[i+1] == 'E') {
+		s = s[:i] + s[i+1:]
+	}
+	s = sign + s
+	return JSONNumber(s), validNumber(s)
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
@@ -0,0 +1,212 @@
+package airp
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+)
+
+var keyRegex = regexp.MustCompile(`[[:alpha:]][[:word:]:\-]*`)
+
+// ParseOptions configures how JSON is read. The zero value accepts every
+// JSON text as defined by RFC 8259 and is used by NewJSON, NewJSONReader
+// and NewJSONString.
+type ParseOptions struct {
+	// ValidateKey is called for every object key. If it returns an error
+	// parsing stops with a ParseError wrapping that error.
+	// A nil ValidateKey accepts all keys.
+	ValidateKey func(key string) error
+
+	// Duplicates decides what happens to repeated keys in an object.
+	Duplicates DuplicatePolicy
+
+	// Surrogates decides what happens to \u escapes of UTF-16 surrogates
+	// that are not part of a pair.
+	Surrogates SurrogatePolicy
+
+	// Syntax selects the dialect of JSON that is accepted.
+	Syntax Syntax
+
+	// Lossless keeps the input with the nodes so that Encode with
+	// EncodeOptions.Preserve writes unchanged parts of the tree exactly as
+	// they were read. The whole input is held in memory.
+	Lossless bool
+
+	// Tolerant continues parsing after syntax errors. A value that cannot
+	// be read becomes a node of type Error and the parser continues at the
+	// next comma or closing bracket. A closing bracket of the wrong kind
+	// closes the arrays and objects up to the one it fits or is skipped if
+	// there is none. Unclosed arrays and objects are closed at the end of
+	// the input. The returned node is the root of the tree and the error is
+	// of type ParseErrors holding all errors.
+	// NewDecoder ignores Tolerant.
+	Tolerant bool
+
+	// MaxErrors is the number of errors after which Tolerant parsing stops.
+	// Zero means 100.
+	MaxErrors int
+
+	// Limits bounds the input accepted from untrusted sources.
+	Limits Limits
+}
+
+// Limits bounds the resources parsing may use. Exceeding a limit stops
+// parsing with a ParseError of the kind named for each limit at the
+// position where it was exceeded. This is also the case for Tolerant
+// parsing. A zero field means no limit except for MaxDepth.
+// A Decoder applies all limits but MaxMembers and MaxNodes.
+type Limits struct {
+	// MaxDepth is the deepest nesting of arrays and objects. Zero means
+	// 10000 like encoding/json. Kind ErrDepthExceeded.
+	MaxDepth int
+	// MaxBytes is the length of the input in bytes. Kind ErrInputTooLarge.
+	MaxBytes int
+	// MaxStringLength is the length in bytes of strings and keys as
+	// written in the input without quotes. Kind ErrStringTooLong.
+	MaxStringLength int
+	// MaxNumberLength is the length in bytes of numbers as written in the
+	// input. Kind ErrNumberTooLong.
+	MaxNumberLength int
+	// MaxMembers is the number of members of each object.
+	// Kind ErrTooManyMembers.
+	MaxMembers int
+	// MaxNodes is the number of values in the input including arrays and
+	// objects. Kind ErrTooManyNodes.
+	MaxNodes int
+}
+
+// depth returns MaxDepth or its default.
+func (l Limits) depth() int {
+	if l.MaxDepth > 0 {
+		return l.MaxDepth
+	}
+	return maxDepth
+}
+
+// maxErrors returns MaxErrors or its default.
+func (o ParseOptions) maxErrors() int {
+	if o.MaxErrors > 0 {
+		return o.MaxErrors
+	}
+	return maxErrors
+}
+
+// Syntax is an enum for the dialects of JSON.
+type Syntax uint8
+
+// Syntaxes for ParseOptions. The zero value is standard JSON.
+const (
+	// SyntaxJSON accepts JSON as defined by RFC 8259.
+	SyntaxJSON Syntax = iota
+	// SyntaxJSON5 accepts JSON5 as defined by https://spec.json5.org.
+	// It adds comments, trailing commas, single quoted and multi-line
+	// strings, identifiers as keys, hexadecimal numbers, numbers with
+	// leading or trailing decimal point or a plus sign, Infinity and NaN.
+	// The nodes are the same as for the equivalent JSON. Numbers are
+	// converted to JSON numbers, Infinity and NaN are held as float64 and
+	// can only be written with EncodeOptions.JSON5.
+	// Comments are kept like in SyntaxJSONC.
+	SyntaxJSON5
+	// SyntaxJSONC accepts JSON with // and /* */ comments and trailing
+	// commas like the settings of VS Code. The parser attaches comments to
+	// the nearest node and writing the node writes them back.
+	SyntaxJSONC
+)
+
+// DuplicatePolicy is an enum for the handling of repeated object keys.
+type DuplicatePolicy uint8
+
+// DuplicatePolicies for ParseOptions. The zero value rejects duplicates.
+const (
+	// DuplicateError stops parsing with a ParseError.
+	DuplicateError DuplicatePolicy = iota
+	// DuplicateLastWins keeps the value of the last occurrence at the
+	// place of the first one.
+	DuplicateLastWins
+	// DuplicateFirstWins keeps the first occurrence and drops the others.
+	DuplicateFirstWins
+	// DuplicateKeepAll keeps all occurrences in order. Methods looking up
+	// a key resolve to the last occurrence like DuplicateLastWins.
+	DuplicateKeepAll
+)
+
+// IdentifierKeys is a key validation policy for ParseOptions.ValidateKey.
+// It only accepts keys starting with a letter followed by letters, digits,
+// '_', ':' or '-'.
+func IdentifierKeys(key string) error {
+	if key != keyRegex.FindString(key) {
+		return fmt.Errorf("key %q is not an identifier", key)
+	}
+	return nil
+}
+
+// SurrogatePolicy is an enum for the handling of lone UTF-16 surrogates.
+type SurrogatePolicy uint8
+
+// SurrogatePolicies for ParseOptions. The zero value replaces lone
+// surrogates.
+const (
+	// SurrogateReplace decodes lone surrogates as U+FFFD.
+	SurrogateReplace SurrogatePolicy = iota
+	// SurrogateError reports lone surrogates as malformed escape sequence.
+	SurrogateError
+)
+
+// configure applies the options concerning the lexer to l.
+func (o ParseOptions) configure(l *lexer) *lexer {
+	l.surrogates = o.Surrogates
+	l.json5 = o.Syntax == SyntaxJSON5
+	l.comments = o.Syntax != SyntaxJSON
+	l.maxString = o.Limits.MaxStringLength
+	l.maxNumber = o.Limits.MaxNumberLength
+	if max := o.Limits.MaxBytes; max > 0 {
+		l.maxBytes = l.base + max
+		if l.reader != nil {
+			l.reader = io.LimitReader(l.reader, int64(max)+1)
+		} else if len(l.data) > max {
+			l.data = l.data[:max]
+			l.truncated = true
+		}
+	}
+	return l
+}
+
+// trailingCommas reports whether a comma may follow the last element of
+// an array or object.
+func (o ParseOptions) trailingCommas() bool {
+	return o.Syntax != SyntaxJSON
+}
+
+// NewJSON reads from b and generates an AST
+func (o ParseOptions) NewJSON(b []byte) (*Node, error) {
+	if o.Lossless {
+		b = append([]byte(nil), b...) // b may change later
+	}
+	return o.parse(lexBytes(b))
+}
+
+// NewJSONReader reads from r and generates an AST. Errors of r other than
+// io.EOF are returned as they are.
+func (o ParseOptions) NewJSONReader(r io.Reader) (*Node, error) {
+	if o.Lossless {
+		if max := o.Limits.MaxBytes; max > 0 {
+			r = io.LimitReader(r, int64(max)+1)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return o.parse(lexBytes(b))
+	}
+	return o.parse(lex(r))
+}
+
+// NewJSONString reads from s and generates an AST
+func (o ParseOptions) NewJSONString(s string) (*Node, error) {
+	return o.parse(lexBytes([]byte(s)))
+}
+
+// NewDecoder creates a Decoder reading from r.
+func (o ParseOptions) NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{lex: o.configure(lex(r)), opts: o}
+}
@@ -1,114 +1,313 @@
 package airp
 
 import (
-	"regexp"
+	"bytes"
 	"strconv"
+	"strings"
 )
 
-var keyRegex = regexp.MustCompile(`[[:alpha:]][[:word:]:\-]*`)
-
 // parser is a state machine creating an ast from lex tokens
 // the parser is only allowed to cancel it if receives an error from the lexer
 type parser struct {
-	in     <-chan token
-	quitIn func()
-	init   parseFunc
-	ast    *Node
-	prev   token
+	lex     *lexer
+	opts    ParseOptions
+	init    parseFunc
+	ast     *Node
+	prev    token
+	pending []string    // comments not yet attached
+	src     []byte      // input of a lossless parser
+	levels  []level     // open arrays and objects
+	nodes   int         // number of values read
+	values  func(*Node) // receives each top-level value of a stream
+	errs    ParseErrors
+}
+
+// level is an array or object the parser is in.
+type level struct {
+	jsonType JSONType
+	index    int    // of the current element of an array
+	key      string // of the current member of an object
+	keyed    bool   // key is read
 }
 
+// maxDepth is the deepest nesting of arrays and objects the parser accepts
+// by default. It is the same as the one of encoding/json.
+const maxDepth = 10000
+
+// maxErrors is the number of errors after which a tolerant parser stops
+// by default.
+const maxErrors = 100
+
 type parseFunc func(p *parser) (parseFunc, error)
 
-// Parse reads tokens from a channel and generates a ast.
+// Parse pulls tokens from a lexer and generates a ast.
 // The returned node is the root node of the ast.
-func parse(ch <-chan token, quit func()) (*Node, error) {
-	defer quit()
-	p := &parser{
-		in:     ch,
-		quitIn: quit,
-		init:   expektValue,
-		ast:    new(Node),
-	}
+func parse(l *lexer) (*Node, error) {
+	return ParseOptions{}.parse(l)
+}
+
+func (o ParseOptions) parse(l *lexer) (*Node, error) {
+	return o.parseValues(l, nil)
+}
+
+// parseValues parses a stream of values and passes each top-level value to
+// values as soon as it is complete. It returns the last one. A nil values
+// parses a single value.
+func (o ParseOptions) parseValues(l *lexer, values func(*Node)) (*Node, error) {
+	p := o.newParser(l)
+	p.values = values
 	var err error
 	for f := p.init; f != nil && err == nil; f, err = f(p) {
 	}
+	return p.finish(err)
+}
+
+// newParser creates a parser reading from l configured by o.
+func (o ParseOptions) newParser(l *lexer) *parser {
+	l = o.configure(l)
+	l.trivia = l.comments
+	l.resume = o.Tolerant
+	p := &parser{
+		lex:  l,
+		opts: o,
+		init: expektValue,
+		ast:  new(Node),
+	}
+	if o.Lossless && l.reader == nil {
+		p.src = l.data
+	}
+	return p
+}
+
+// finish returns the tree of p and the error after parsing stopped with
+// err.
+func (p *parser) finish(err error) (*Node, error) {
+	if p.lex.readErr != nil {
+		return nil, p.lex.readErr
+	}
+	if pErr, ok := err.(*ParseError); ok {
+		pErr.complete(p.lex)
+		if p.opts.Tolerant { // limits stop tolerant parsing
+			p.errs = append(p.errs, pErr)
+			for p.ast.parent != nil {
+				p.ast = p.ast.parent
+			}
+		}
+	}
+	if len(p.errs) > 0 {
+		return p.ast, p.errs
+	}
 	return p.ast, err
 }
 
+// next returns the next token that is not a comment. Comments are
+// attached to the node they belong to.
+func (p *parser) next() (token, bool) {
+	for {
+		t, ok := p.lex.next()
+		if ok && t.Type == commentToken {
+			p.comment(t)
+			continue
+		}
+		if len(p.pending) > 0 {
+			p.attach(t, ok)
+		}
+		return t, ok
+	}
+}
+
+// comment attaches a comment on the line of the previous value or comma to
+// that value. Other comments wait for the next token.
+func (p *parser) comment(t token) {
+	if len(p.pending) > 0 || t.position[0] != p.prev.position[0] {
+		p.pending = append(p.pending, t.value)
+		return
+	}
+	switch p.prev.Type {
+	case commaToken:
+		// p.ast is the next element
+		if p.ast.parent == nil {
+			break
+		}
+		switch nn := p.ast.parent.value.(type) {
+		case []*Node:
+			if len(nn) > 1 {
+				nn[len(nn)-2].addComment(TrailingComment, t.value)
+				return
+			}
+		case []KeyNode:
+			if len(nn) > 1 {
+				nn[len(nn)-2].addComment(TrailingComment, t.value)
+				return
+			}
+		}
+		p.pending = append(p.pending, t.value)
+	case arrayCToken, objectCToken, nullToken, trueToken, falseToken,
+		numberToken, stringToken, identToken:
+		if p.ast.jsonType != Error {
+			p.ast.addComment(TrailingComment, t.value)
+			return
+		}
+		fallthrough // key of an object member
+	default:
+		p.pending = append(p.pending, t.value)
+	}
+}
+
+// attach attaches the pending comments depending on the token after them.
+func (p *parser) attach(t token, ok bool) {
+	switch {
+	case ok && p.ast.parent != nil && (t.Type == arrayCToken || t.Type == objectCToken):
+		p.ast.parent.addComment(InnerComment, p.pending...)
+	case !ok || t.Type == commaToken:
+		p.ast.addComment(TrailingComment, p.pending...)
+	default:
+		p.ast.addComment(LeadingComment, p.pending...)
+	}
+	p.pending = nil
+}
+
 // parseFunc's
 
 func expektKey(p *parser) (parseFunc, error) {
-	t := <-p.in
+	t, ok := p.next()
 	if p.ast.parent == nil || p.ast.parent.jsonType != Object {
 		panic("invariant violation: expect key while not in object")
 	}
-	if t.Type == objectCToken {
-		if kn, ok := p.ast.parent.value.([]KeyNode); ok && len(kn) == 1 {
-			p.ast.parent.value = []KeyNode(nil)
-			p.ast = p.ast.parent
-			return expektDelim, nil
+	if t.Type == objectCToken || p.lex.repair && t.Type == arrayCToken {
+		defer func() { p.prev = t }()
+		if len(p.ast.parent.value.([]KeyNode)) > 1 && !p.opts.trailingCommas() {
+			if err := p.dangling(p.newError("key", t)); err != nil {
+				return nil, err
+			}
 		}
+		p.dropLast()
+		return p.bracket(t)
 	}
-	if t.Type != stringToken {
-		return nil, newParseError("key", p.prev, t, p.ast)
+	if !ok && p.lex.repair {
+		return p.truncated(t)
+	}
+	if p.lex.repair && (t.identifier() || t.Type == numberToken) {
+		p.fix(FixQuoteKey, t)
+	} else if t.Type != stringToken && !(p.opts.Syntax == SyntaxJSON5 && t.identifier()) {
+		if err := p.report(p.newError("key", t)); err != nil {
+			return nil, err
+		}
+		return p.skipMember(t, ok)
 	}
-	if t.value != keyRegex.FindString(t.value) {
-		return nil, newParseError("valid key", p.prev, t, p.ast)
+	if max := p.opts.Limits.MaxMembers; max > 0 && len(p.ast.parent.value.([]KeyNode)) > max {
+		return nil, p.exceeded(ErrTooManyMembers, t)
+	}
+	if p.opts.ValidateKey != nil {
+		if err := p.opts.ValidateKey(t.value); err != nil {
+			pErr := p.newError("valid key", t)
+			pErr.err = err
+			if err := p.report(pErr); err != nil {
+				return nil, err
+			}
+		}
 	}
 	pp := p.ast.parent.value.([]KeyNode)
-	for _, kn := range pp {
-		if kn.Key == t.value {
-			return nil, newParseError("unique key", p.prev, t, p.ast)
+	if p.opts.Duplicates == DuplicateError {
+		for _, kn := range pp[:len(pp)-1] {
+			if kn.Key == t.value {
+				pErr := p.newError("", t)
+				pErr.kind = ErrDuplicateKey
+				if err := p.report(pErr); err != nil {
+					return nil, err
+				}
+				break
+			}
 		}
 	}
 	if pp[len(pp)-1].Node != p.ast {
 		panic("not 'this'")
 	}
 	pp[len(pp)-1].Key = t.value
-	p.prev, t = t, <-p.in
+	top := &p.levels[len(p.levels)-1]
+	top.key, top.keyed = t.value, true
+	p.recordKey(t)
+	p.prev = t
+	return expektColon, nil
+}
+
+func expektColon(p *parser) (parseFunc, error) {
+	t, ok := p.next()
 	defer func() { p.prev = t }()
+	if !ok && p.lex.repair {
+		return p.truncated(t)
+	}
 	if t.Type != colonToken {
-		return nil, newParseError("colon", p.prev, t, p.ast)
+		return p.invalid(p.newError("colon", t), t, ok)
 	}
 	return expektValue, nil
 }
 
 func expektValue(p *parser) (parseFunc, error) {
-	t := <-p.in
+	return p.value(p.next())
+}
+
+// value reads the value p.ast starting with the token t.
+func (p *parser) value(t token, ok bool) (parseFunc, error) {
 	defer func() { p.prev = t }()
-	if p.ast.parent != nil && t.Type == arrayCToken {
-		if nn, ok := p.ast.parent.value.([]*Node); ok && len(nn) == 1 {
-			p.ast.parent.value = []*Node(nil)
-			p.ast = p.ast.parent
-			return expektDelim, nil
+	if p.ast.parent != nil && (t.Type == arrayCToken || p.lex.repair && t.Type == objectCToken) {
+		switch nn := p.ast.parent.value.(type) {
+		case []*Node:
+			if len(nn) > 1 && !p.opts.trailingCommas() {
+				if err := p.dangling(p.newError("value", t)); err != nil {
+					return nil, err
+				}
+			}
+			p.dropLast()
+			return p.bracket(t)
+		case []KeyNode:
+			if p.lex.repair {
+				p.fix(FixDropMember, t)
+				p.dropLast()
+				return p.bracket(t)
+			}
 		}
 	}
+	if !ok && p.lex.repair && p.ast.parent != nil {
+		return p.truncated(t)
+	}
+	if p.nodes++; p.opts.Limits.MaxNodes > 0 && p.nodes > p.opts.Limits.MaxNodes {
+		return nil, p.exceeded(ErrTooManyNodes, t)
+	}
+	p.record(t)
 	switch t.Type {
-	case numberToken:
-		p.ast.jsonType = Number
-		// number check
-		num, err := strconv.ParseFloat(t.value, 64)
-		if err != nil {
-			return nil, newParseError("number", p.prev, t, p.ast)
+	case numberToken, identToken:
+		v, isNumber := parseNumber(t.value, p.opts.Syntax)
+		if !isNumber && t.Type == identToken {
+			return p.invalid(p.newError("value", t), t, ok)
+		} else if !isNumber {
+			pErr := p.newError("number", t)
+			pErr.kind = ErrInvalidNumber
+			return p.invalid(pErr, t, ok)
 		}
-		p.ast.value = num
-		return expektDelim, nil
+		p.ast.jsonType = Number
+		p.ast.value = v
+		return p.end()
 	case stringToken:
 		p.ast.jsonType = String
 		p.ast.value = t.value
-		return expektDelim, nil
+		return p.end()
 	case nullToken:
 		p.ast.jsonType = Null
-		return expektDelim, nil
+		return p.end()
 	case trueToken:
 		p.ast.jsonType = Bool
 		p.ast.value = true
-		return expektDelim, nil
+		return p.end()
 	case falseToken:
 		p.ast.jsonType = Bool
 		p.ast.value = false
-		return expektDelim, nil
+		return p.end()
 	case arrayOToken:
+		if len(p.levels) == p.opts.Limits.depth() {
+			return p.invalid(p.exceeded(ErrDepthExceeded, t), t, ok)
+		}
+		p.levels = append(p.levels, level{jsonType: Array})
 		p.ast.jsonType = Array
 		nn := make([]*Node, 1, 4)
 		nn[0] = new(Node)
@@ -117,6 +316,10 @@ func expektValue(p *parser) (parseFunc, error) {
 		p.ast = nn[0]
 		return expektValue, nil
 	case objectOToken:
+		if len(p.levels) == p.opts.Limits.depth() {
+			return p.invalid(p.exceeded(ErrDepthExceeded, t), t, ok)
+		}
+		p.levels = append(p.levels, level{jsonType: Object})
 		p.ast.jsonType = Object
 		kn := make([]KeyNode, 1, 4)
 		kn[0].Node = new(Node)
@@ -125,56 +328,458 @@ func expektValue(p *parser) (parseFunc, error) {
 		p.ast = kn[0].Node
 		return expektKey, nil
 	default:
-		return nil, newParseError("value", p.prev, t, p.ast)
+		return p.invalid(p.newError("value", t), t, ok)
 	}
 }
 
 func expektDelim(p *parser) (parseFunc, error) {
-	t, ok := <-p.in
+	return p.delim(p.next())
+}
+
+// end continues after the complete value p.ast. A top-level value of a
+// stream is passed on at once.
+func (p *parser) end() (parseFunc, error) {
+	if p.ast.parent == nil && p.values != nil {
+		p.values(p.ast)
+		return expektNext, nil
+	}
+	return expektDelim, nil
+}
+
+// expektNext starts the next top-level value of a stream. The stream may
+// end before it.
+func expektNext(p *parser) (parseFunc, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, nil
+	}
+	p.ast, p.nodes = new(Node), 0
+	return p.value(t, ok)
+}
+
+// delim continues after the value p.ast with the token t following it.
+func (p *parser) delim(t token, ok bool) (parseFunc, error) {
 	defer func() { p.prev = t }()
 	if !ok {
 		if p.ast.parent == nil {
 			return nil, nil // all OK!
 		}
-		return nil, newParseError("delimiter", p.prev, p.prev, p.ast)
+		if p.lex.repair {
+			return p.unwind(t)
+		}
+		pErr := p.newError("delimiter", p.prev)
+		pErr.kind = ErrUnexpectedEOF
+		if err := p.report(pErr); err != nil {
+			return nil, err
+		}
+		return p.unwind(t)
 	}
 	switch t.Type {
 	case commaToken:
 		if p.ast.parent == nil {
-			return nil, newParseError("no comma", p.prev, t, p.ast)
+			if err := p.report(p.newError("end of input", t)); err != nil {
+				return nil, err
+			}
+			return p.delim(p.skip(t, ok))
 		}
+		top := &p.levels[len(p.levels)-1]
 		if p.ast.parent.jsonType == Array {
+			top.index++
 			p.ast.parent.value = append(p.ast.parent.value.([]*Node), &Node{parent: p.ast.parent})
 			p.ast = p.ast.parent.value.([]*Node)[len(p.ast.parent.value.([]*Node))-1]
 			return expektValue, nil
 		}
 		if p.ast.parent.jsonType == Object {
+			top.keyed = false
 			p.ast.parent.value = append(p.ast.parent.value.([]KeyNode), KeyNode{Node: &Node{parent: p.ast.parent}})
 			p.ast = p.ast.parent.value.([]KeyNode)[len(p.ast.parent.value.([]KeyNode))-1].Node
 			return expektKey, nil
 		}
-		return nil, newParseError("no comma", p.prev, t, p.ast)
+		return nil, p.newError("no comma", t)
 	case arrayCToken, objectCToken:
 		if p.ast.parent == nil {
-			return nil, newParseError("to be in array or object", p.prev, t, p.ast)
+			if err := p.report(p.newError("end of input", t)); err != nil {
+				return nil, err
+			}
+			return p.delim(p.skip(t, ok))
+		}
+		return p.bracket(t)
+	default:
+		if err := p.report(p.newError("delimiter", t)); err != nil {
+			return nil, err
+		}
+		return p.delim(p.skip(t, ok))
+	}
+}
+
+// report returns e to stop parsing. A tolerant parser records e instead
+// and returns nil to continue unless e exceeds Limits or is the last of
+// MaxErrors. Only the first error at a position and at the end of the
+// input is recorded.
+func (p *parser) report(e *ParseError) error {
+	if !p.opts.Tolerant {
+		return e
+	}
+	e.complete(p.lex)
+	if isLimit(e.kind) {
+		return e
+	}
+	if n := len(p.errs); n > 0 && (p.errs[n-1].Offset() == e.Offset() ||
+		p.errs[n-1].kind == ErrUnexpectedEOF && e.kind == ErrUnexpectedEOF) {
+		return nil
+	}
+	if len(p.errs)+1 >= p.opts.maxErrors() {
+		return e
+	}
+	p.errs = append(p.errs, e)
+	return nil
+}
+
+// newError creates a ParseError at the token t expecting msg.
+func (p *parser) newError(msg string, t token) *ParseError {
+	return &ParseError{
+		kind:       tokenErr(t),
+		msg:        msg,
+		before:     p.prev,
+		token:      t,
+		parentType: parentType(p.ast),
+		key:        p.key(),
+	}
+}
+
+// key returns the key of p.ast in the style of Node.Key.
+func (p *parser) key() string {
+	var b strings.Builder
+	for i, l := range p.levels {
+		if l.jsonType == Object && !l.keyed {
+			break // no key yet
+		}
+		if i > 0 {
+			b.WriteByte('.')
 		}
-		switch p.ast.parent.jsonType {
-		case Array:
-			if t.Type != arrayCToken {
-				return nil, newParseError("array closing", p.prev, t, p.ast)
+		if l.jsonType == Array {
+			b.WriteString(strconv.Itoa(l.index))
+		} else {
+			b.WriteString(l.key)
+		}
+	}
+	return b.String()
+}
+
+// dangling reports the error e of the comma before a closing bracket. A
+// repairing parser drops the comma instead.
+func (p *parser) dangling(e *ParseError) error {
+	if p.lex.repair {
+		p.fix(FixDropComma, p.prev)
+		return nil
+	}
+	return p.report(e)
+}
+
+// truncated repairs the input that ends at t before the value or key of
+// p.ast. The unfinished element is dropped.
+func (p *parser) truncated(t token) (parseFunc, error) {
+	switch p.prev.Type {
+	case commaToken:
+		p.fix(FixDropComma, p.prev)
+	case arrayOToken, objectOToken:
+		// empty
+	default:
+		p.fix(FixDropMember, t)
+	}
+	p.dropLast()
+	return p.unwind(t)
+}
+
+// fix records the fix k caused by t if the input is repaired.
+func (p *parser) fix(k FixKind, t token) {
+	if p.lex.repair {
+		p.lex.fixes = append(p.lex.fixes, Fix{Kind: k, Pos: t.pos()})
+	}
+}
+
+// invalid reports the error e of the value p.ast starting with t.
+// A tolerant parser keeps p.ast as Error node and continues after it.
+func (p *parser) invalid(e *ParseError, t token, ok bool) (parseFunc, error) {
+	if err := p.report(e); err != nil {
+		return nil, err
+	}
+	p.ast.jsonType, p.ast.value = Error, nil
+	return p.delim(p.skip(t, ok))
+}
+
+// skipMember continues after the object member p.ast with the invalid key
+// t. The member is dropped.
+func (p *parser) skipMember(t token, ok bool) (parseFunc, error) {
+	key := t
+	t, ok = p.skip(t, ok)
+	defer func() { p.prev = t }()
+	var pErr *ParseError
+	switch {
+	case t.Type == commaToken:
+		t = key // the comma belongs to the dropped member
+		return expektKey, nil // p.ast is used for the next member
+	case !ok:
+		pErr = p.newError("delimiter", p.prev)
+		pErr.kind = ErrUnexpectedEOF
+	case t.Type == arrayCToken && t != key:
+		pErr = p.newError("object closing", t)
+	}
+	if pErr != nil {
+		if err := p.report(pErr); err != nil {
+			return nil, err
+		}
+	}
+	p.dropLast()
+	if !ok {
+		return p.unwind(t)
+	}
+	p.closeTo(t)
+	return p.end()
+}
+
+// skip discards the tokens from t on up to the next comma or closing
+// bracket of the array or object p.ast is in and returns it. Nested arrays
+// and objects are discarded as a whole. At the top-level the rest of the
+// input is discarded.
+func (p *parser) skip(t token, ok bool) (token, bool) {
+	top := p.ast.parent == nil
+	for depth := 0; ok; t, ok = p.next() {
+		switch t.Type {
+		case arrayOToken, objectOToken:
+			depth++
+		case arrayCToken, objectCToken:
+			if depth == 0 && !top {
+				return t, ok
+			}
+			if depth > 0 {
+				depth--
 			}
-			p.ast = p.ast.parent
-			return expektDelim, nil
-		case Object:
-			if t.Type != objectCToken {
-				return nil, newParseError("object closing", p.prev, t, p.ast)
+		case commaToken:
+			if depth == 0 && !top {
+				return t, ok
+			}
+		}
+	}
+	return t, ok
+}
+
+// bracket leaves the array or object p.ast is in at the closing bracket t.
+// A bracket of the wrong kind is reported and closes the arrays and objects
+// up to the one it fits or is skipped if there is none. A repairing parser
+// closes them as well or replaces t if it fits none.
+func (p *parser) bracket(t token) (parseFunc, error) {
+	if closes(t) != p.ast.parent.jsonType {
+		switch {
+		case p.lex.repair:
+			if !p.encloses(t) {
+				p.fix(FixReplaceBracket, t)
 			}
-			p.ast = p.ast.parent
-			return expektDelim, nil
 		default:
-			return nil, newParseError("to be in array or object", p.prev, t, p.ast)
+			msg := "array closing"
+			if p.ast.parent.jsonType == Object {
+				msg = "object closing"
+			}
+			if err := p.report(p.newError(msg, t)); err != nil {
+				return nil, err
+			}
+			if !p.encloses(t) {
+				return expektDelim, nil // t is skipped
+			}
 		}
-	default:
-		return nil, newParseError("delimiter", p.prev, t, p.ast)
 	}
+	p.closeTo(t)
+	return p.end()
+}
+
+// closes returns the type of the container the closing bracket t closes.
+func closes(t token) JSONType {
+	if t.Type == arrayCToken {
+		return Array
+	}
+	return Object
+}
+
+// encloses reports whether the closing bracket t fits an array or object
+// p.ast is in.
+func (p *parser) encloses(t token) bool {
+	for _, l := range p.levels {
+		if l.jsonType == closes(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeTo leaves the arrays and objects up to the innermost one the
+// closing bracket t fits. If there is none only the one p.ast is in is
+// left. Those t does not fit are recorded as fixes.
+func (p *parser) closeTo(t token) {
+	n := 1
+	for i := len(p.levels) - 1; i >= 0; i-- {
+		if p.levels[i].jsonType == closes(t) {
+			n = len(p.levels) - i
+			break
+		}
+	}
+	for ; n > 0; n-- {
+		p.close(t)
+		switch {
+		case p.ast.jsonType == Object:
+			dropDuplicates(p.ast, p.opts.Duplicates)
+			if n > 1 {
+				p.fix(FixCloseObject, t)
+			}
+		case n > 1:
+			p.fix(FixCloseArray, t)
+		}
+	}
+}
+
+// unwind closes all open arrays and objects at the end of the input t.
+func (p *parser) unwind(t token) (parseFunc, error) {
+	for p.ast.parent != nil {
+		p.close(t)
+		if p.ast.jsonType == Object {
+			p.fix(FixCloseObject, t)
+			dropDuplicates(p.ast, p.opts.Duplicates)
+		} else {
+			p.fix(FixCloseArray, t)
+		}
+	}
+	return nil, nil
+}
+
+// dropLast removes the unfinished last element p.ast from its parent.
+func (p *parser) dropLast() {
+	switch nn := p.ast.parent.value.(type) {
+	case []*Node:
+		nn[len(nn)-1] = nil
+		if nn = nn[:len(nn)-1]; len(nn) == 0 {
+			nn = nil
+		}
+		p.ast.parent.value = nn
+	case []KeyNode:
+		nn[len(nn)-1] = KeyNode{}
+		if nn = nn[:len(nn)-1]; len(nn) == 0 {
+			nn = nil
+		}
+		p.ast.parent.value = nn
+	}
+}
+
+// close leaves the array or object p.ast is in at its closing bracket t.
+func (p *parser) close(t token) {
+	p.ast = p.ast.parent
+	p.levels = p.levels[:len(p.levels)-1]
+	p.recordEnd(t)
+}
+
+// exceeded returns the error of kind for the token t beyond a limit.
+func (p *parser) exceeded(kind error, t token) *ParseError {
+	pErr := p.newError("", t)
+	pErr.kind = kind
+	return pErr
+}
+
+// record notes where the value p.ast starting with t is in the input.
+func (p *parser) record(t token) {
+	p.ast.span = Span{Start: t.span[0], End: t.span[1]}
+	if p.src == nil {
+		return
+	}
+	c := p.ast.cst
+	if c == nil {
+		c = &cst{keyStart: t.span[0].Offset}
+		p.ast.cst = c
+	}
+	c.src, c.start, c.end = p.src, t.span[0].Offset, t.span[1].Offset
+	switch t.Type {
+	case numberToken, identToken:
+		c.jsonType = Number
+	case stringToken:
+		c.jsonType = String
+	case nullToken:
+		c.jsonType = Null
+	case trueToken, falseToken:
+		c.jsonType = Bool
+	case arrayOToken:
+		c.jsonType = Array
+	case objectOToken:
+		c.jsonType = Object
+	}
+	if m := p.ast.parent; m != nil {
+		c.owner = m.cst
+		c.idx = m.Len() - 1
+	}
+}
+
+// recordKey notes where the key t of the object member p.ast is in the
+// input.
+func (p *parser) recordKey(t token) {
+	p.ast.keySpan = Span{Start: t.span[0], End: t.span[1]}
+	if p.src != nil {
+		p.ast.cst = &cst{keyStart: t.span[0].Offset, keyEnd: t.span[1].Offset, key: t.value}
+	}
+}
+
+// recordEnd notes the end of the array or object p.ast at the closing
+// bracket t.
+func (p *parser) recordEnd(t token) {
+	p.ast.span.End = t.span[1]
+	if p.src == nil {
+		return
+	}
+	c := p.ast.cst
+	c.end = t.span[1].Offset
+	var nn []*Node
+	switch v := p.ast.value.(type) {
+	case []*Node:
+		nn = v
+	case []KeyNode:
+		for _, kn := range v {
+			nn = append(nn, kn.Node)
+		}
+	}
+	if c.len = len(nn); c.len == 0 {
+		return
+	}
+	c.first, c.last = nn[0].cst.keyStart, nn[c.len-1].cst.end
+	if c.len > 1 {
+		c.sep = space(c.text(nn[0].cst.end, nn[1].cst.keyStart))
+	}
+	if c.jsonType == Object {
+		colon := c.text(nn[0].cst.keyEnd, nn[0].cst.start)
+		c.colonSep = space(colon[bytes.IndexByte(colon, ':')+1:])
+	}
+}
+
+// dropDuplicates removes repeated keys from the object n as policy demands.
+func dropDuplicates(n *Node, policy DuplicatePolicy) {
+	kn := n.value.([]KeyNode)
+	if len(kn) < 2 || (policy != DuplicateLastWins && policy != DuplicateFirstWins) {
+		return
+	}
+	seen := make(map[string]int, len(kn))
+	out := kn[:0]
+	for _, m := range kn {
+		i, ok := seen[m.Key]
+		switch {
+		case !ok:
+			seen[m.Key] = len(out)
+			out = append(out, m)
+		case policy == DuplicateLastWins:
+			out[i].parent = nil
+			out[i].Node = m.Node
+		default:
+			m.parent = nil
+		}
+	}
+	for i := len(out); i < len(kn); i++ {
+		kn[i] = KeyNode{}
+	}
+	if len(out) < len(kn) {
+		n.touch()
+	}
+	n.value = out
 }
@@ -0,0 +1,113 @@
+package airp
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Pipeline transforms the values of a StreamDecoder concurrently and
+// writes them to a StreamEncoder in the order they were read.
+type Pipeline struct {
+	// Workers is the number of values transformed at once. Zero means
+	// runtime.GOMAXPROCS(0).
+	Workers int
+	// OnError is called with values that cannot be read or transformed.
+	// If it returns nil the value is dropped, otherwise Run stops with the
+	// returned error. A nil OnError stops at the first error.
+	OnError func(err *RecordError) error
+}
+
+// pipelineItem is a value of a Pipeline on its way to the writer.
+type pipelineItem struct {
+	n      *Node
+	err    error
+	record int // index in the stream
+	line   int // line in the stream
+	done   chan struct{}
+}
+
+// Run reads all values of src, applies fn to them and writes the results
+// to dst. A nil result of fn drops the value. Errors of fn are passed to
+// OnError as *RecordError. Errors of the reader or of dst stop Run.
+func (p Pipeline) Run(dst *StreamEncoder, src *StreamDecoder, fn func(*Node) (*Node, error)) error {
+	workers := p.Workers
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	jobs := make(chan *pipelineItem)
+	queue := make(chan *pipelineItem, workers) // in input order
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for it := range jobs {
+				n, err := fn(it.n)
+				if err != nil {
+					err = &RecordError{Record: it.record, Line: it.line, Err: err}
+				}
+				it.n, it.err = n, err
+				close(it.done)
+			}
+		}()
+	}
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(queue)
+		for {
+			n, err := src.Next()
+			if err == io.EOF {
+				return
+			}
+			it := &pipelineItem{n: n, err: err, done: make(chan struct{})}
+			if err != nil {
+				close(it.done)
+			} else {
+				it.record, it.line = src.record-1, src.start.Line
+			}
+			select {
+			case queue <- it:
+			case <-stop:
+				return
+			}
+			if err != nil {
+				if _, ok := err.(*RecordError); !ok {
+					return // the reader failed
+				}
+				continue
+			}
+			select {
+			case jobs <- it:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	err := p.write(dst, queue)
+	close(stop)
+	wg.Wait()
+	return err
+}
+
+// write writes the items of queue to dst as they are done.
+func (p Pipeline) write(dst *StreamEncoder, queue <-chan *pipelineItem) error {
+	for it := range queue {
+		<-it.done
+		if rErr, ok := it.err.(*RecordError); ok && p.OnError != nil {
+			it.err = p.OnError(rErr)
+		}
+		if it.err != nil {
+			return it.err
+		}
+		if it.n == nil {
+			continue
+		}
+		if err := dst.Encode(it.n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package airp
+
+import "io"
+
+// PushParser parses JSON that arrives in chunks of arbitrary size. Chunks
+// may end within any token. The input is a stream of top-level values
+// separated by optional white space like `{"a":1} [2] 3`.
+// ParseOptions.Tolerant and Lossless have no effect and Limits apply to
+// each value but MaxBytes, which bounds the whole stream.
+type PushParser struct {
+	p    *parser
+	f    parseFunc // next state of p; nil after the end
+	last *Node     // last complete value; set at the end
+	err  error
+}
+
+// NewPushParser creates a PushParser. values is called with each top-level
+// value as soon as it is complete. It may be nil.
+func NewPushParser(values func(*Node)) *PushParser {
+	return ParseOptions{}.NewPushParser(values)
+}
+
+// NewPushParser creates a PushParser. values is called with each top-level
+// value as soon as it is complete. It may be nil.
+func (o ParseOptions) NewPushParser(values func(*Node)) *PushParser {
+	o.Tolerant, o.Lossless = false, false
+	p := o.newParser(lexPush())
+	p.values = func(n *Node) { // a stream even without values
+		if values != nil {
+			values(n)
+		}
+	}
+	return &PushParser{p: p, f: p.init}
+}
+
+// Write parses chunk. The values completed by chunk are passed on before
+// Write returns. After a syntax error Write returns it and consumes no
+// more input. chunk is not retained.
+func (p *PushParser) Write(chunk []byte) (int, error) {
+	if p.f == nil {
+		if p.err != nil {
+			return 0, p.err
+		}
+		return 0, io.ErrClosedPipe
+	}
+	if len(chunk) == 0 {
+		return 0, nil
+	}
+	p.p.lex.push(chunk)
+	if p.run(); p.err != nil {
+		return 0, p.err
+	}
+	return len(chunk), nil
+}
+
+// Close ends the input and returns the last top-level value and the error
+// like NewJSON does. Input that ends within a value is a *ParseError as well
+// as input without any value.
+func (p *PushParser) Close() (*Node, error) {
+	if p.f != nil {
+		p.p.lex.push(nil)
+		p.run()
+	}
+	return p.last, p.err
+}
+
+// run parses as far as the input pushed so far allows. Each step of the
+// parser reads a single token the lexer has ready.
+func (p *PushParser) run() {
+	var err error
+	for p.f != nil && err == nil && p.p.lex.available() {
+		p.f, err = p.f(p.p)
+	}
+	if err != nil || p.f == nil {
+		p.f = nil
+		p.last, p.err = p.p.finish(err)
+	}
+}
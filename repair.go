@@ -0,0 +1,61 @@
+package airp
+
+// FixKind is an enum for the changes Repair makes to its input.
+type FixKind uint8
+
+//go:generate stringer -type FixKind
+
+// FixKinds of a Fix.
+const (
+	// FixCloseString closes a string cut off by the end of the input. An
+	// incomplete escape sequence at its end is dropped.
+	FixCloseString FixKind = iota
+	// FixCloseArray closes an array at the end of the input or before the
+	// closing bracket of an enclosing array or object.
+	FixCloseArray
+	// FixCloseObject closes an object at the end of the input or before the
+	// closing bracket of an enclosing array or object.
+	FixCloseObject
+	// FixDropComma drops a comma before a closing bracket or the end of
+	// the input.
+	FixDropComma
+	// FixDropMember drops an object member without value at the end of the
+	// input.
+	FixDropMember
+	// FixQuoteKey quotes an object key that is an identifier or a number.
+	FixQuoteKey
+	// FixSingleQuotes reads a string in single quotes.
+	FixSingleQuotes
+	// FixCompleteLiteral completes true, false or null cut off by the end
+	// of the input.
+	FixCompleteLiteral
+	// FixCompleteNumber drops an incomplete sign, decimal point or exponent
+	// at the end of a number cut off by the end of the input. A lone sign
+	// is dropped with its member or element.
+	FixCompleteNumber
+	// FixReplaceBracket replaces a closing bracket that fits no open array
+	// or object by the one of the current array or object.
+	FixReplaceBracket
+)
+
+// Fix is a change Repair made to its input. Pos is the start of the token
+// that caused it. At the end of the input it is the end of the input.
+type Fix struct {
+	Kind FixKind
+	Pos  Position
+}
+
+// Repair reads the malformed or truncated JSON in data and returns it as a
+// valid tree together with the fixes it needed in the order they were
+// made. Every deviation from JSON it accepts is one of the FixKinds; other
+// JSON5 syntax is not accepted. Errors that cannot be fixed are returned
+// as *ParseError.
+func Repair(data []byte) (*Node, []Fix, error) {
+	l := lexBytes(data)
+	l.repair = true
+	n, err := parse(l)
+	if err != nil {
+		return nil, nil, err
+	}
+	return n, l.fixes, nil
+}
@@ -0,0 +1,19 @@
+package airp
+
+// Span is the part of the input a node was read from. End is the position
+// after the last byte.
+type Span struct {
+	Start, End Position
+}
+
+// Span returns where n was read from. ok is false if n was not read by a
+// parser. Changing n does not change its span.
+func (n *Node) Span() (s Span, ok bool) {
+	return n.span, n.span.End.Offset > n.span.Start.Offset
+}
+
+// KeySpan returns where the key of the object member n was read from.
+// ok is false if n is no object member or was not read by a parser.
+func (n *Node) KeySpan() (s Span, ok bool) {
+	return n.keySpan, n.keySpan.End.Offset > n.keySpan.Start.Offset
+}
@@ -0,0 +1,291 @@
+package airp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Framing is an enum for the ways values are separated in a stream.
+type Framing uint8
+
+// Framings for a StreamDecoder. The zero value is concatenated JSON.
+const (
+	// FramingConcat reads values separated by optional white space. After
+	// a bad value reading continues on the next line.
+	FramingConcat Framing = iota
+	// FramingLines reads one value per line like NDJSON and JSON Lines.
+	// Empty lines are skipped.
+	FramingLines
+	// FramingRecords reads values each starting with the record separator
+	// U+001E as defined by RFC 7464. Empty records are skipped.
+	FramingRecords
+)
+
+// RecordError is an error in a value of a stream. The stream continues
+// after it.
+type RecordError struct {
+	Record int   // index of the value in the stream counting bad ones
+	Line   int   // line where the value starts
+	Err    error // usually a *ParseError
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("record %d at line %d: %v", e.Record, e.Line, e.Err)
+}
+
+// Unwrap returns the error of the record.
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// StreamDecoder reads a stream of top-level values like log files.
+// Positions are relative to the start of the stream. Limits apply to each
+// value; MaxBytes bounds each line or record and the whole stream with
+// FramingConcat. ParseOptions.Tolerant and Lossless have no effect.
+type StreamDecoder struct {
+	opts   ParseOptions
+	rec    *recordReader // source of lines and records
+	buf    []byte        // data of the lexer of the last record
+	p      *parser
+	f      parseFunc
+	value  *Node    // last complete value
+	start  Position // of the current value
+	record int      // index of the current value
+	err    error    // error of the reader
+}
+
+// NewStreamDecoder creates a StreamDecoder reading from r.
+func NewStreamDecoder(r io.Reader, framing Framing) *StreamDecoder {
+	return ParseOptions{}.NewStreamDecoder(r, framing)
+}
+
+// NewStreamDecoder creates a StreamDecoder reading from r.
+func (o ParseOptions) NewStreamDecoder(r io.Reader, framing Framing) *StreamDecoder {
+	o.Tolerant, o.Lossless = false, false
+	d := &StreamDecoder{opts: o}
+	switch framing {
+	case FramingLines:
+		d.rec = &recordReader{r: bufio.NewReader(r), delim: '\n'}
+	case FramingRecords:
+		d.rec = &recordReader{r: bufio.NewReader(r), delim: '\x1e'}
+	default:
+		d.p = o.newParser(lex(r))
+		d.p.values = func(n *Node) { d.value = n }
+		d.f = d.first
+	}
+	return d
+}
+
+// Next returns the next value of the stream. After the last one it returns
+// io.EOF. Errors in a value are of type *RecordError and Next may be called
+// again to continue with the following value. Other errors are the errors
+// of the reader and end the stream.
+func (d *StreamDecoder) Next() (*Node, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.rec == nil {
+		return d.nextValue()
+	}
+	for {
+		if !d.rec.next() {
+			d.err = d.rec.err
+			return nil, d.err
+		}
+		n, err := d.nextRecord()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if err != nil || n != nil {
+			return n, err
+		}
+	}
+}
+
+// nextValue reads the next value of concatenated JSON.
+func (d *StreamDecoder) nextValue() (*Node, error) {
+	var err error
+	d.value = nil
+	for d.f != nil && d.value == nil && err == nil {
+		d.f, err = d.f(d.p)
+	}
+	if d.value != nil {
+		d.f = d.first
+		d.record++
+		return d.value, nil
+	}
+	if err == nil {
+		return nil, io.EOF
+	}
+	if _, err = d.p.finish(err); err == d.p.lex.readErr {
+		d.err = err
+		return nil, err
+	}
+	d.resync(err.(*ParseError))
+	return nil, d.fail(err)
+}
+
+// resync continues concatenated JSON after the error e. It restarts at the
+// opening bracket of e on a later line or skips the rest of the line.
+func (d *StreamDecoder) resync(e *ParseError) {
+	p := d.p
+	p.ast, p.prev, p.pending, p.levels, p.nodes = new(Node), token{}, nil, p.levels[:0], 0
+	t := e.token
+	if (t.Type == arrayOToken || t.Type == objectOToken) && t.pos().Line > d.start.Line {
+		d.f = func(p *parser) (parseFunc, error) {
+			d.start = t.pos()
+			return p.value(t, true)
+		}
+		return
+	}
+	p.lex.mode = lineMode
+	d.f = d.first
+}
+
+// nextRecord reads the line or record that starts at the current position
+// of d.rec. It returns nil for empty records.
+func (d *StreamDecoder) nextRecord() (*Node, error) {
+	d.start = d.rec.pos
+	l := &lexer{
+		mode:   noneMode,
+		reader: d.rec,
+		data:   d.buf[:0],
+		row:    d.start.Line,
+		col:    d.start.Column,
+		base:   d.start.Offset,
+	}
+	if l.data == nil {
+		l.data = make([]byte, 0, minRead+excerptWidth)
+	}
+	d.p = d.opts.newParser(l)
+	var err error
+	for f := parseFunc(d.first); f != nil && err == nil; f, err = f(d.p) {
+	}
+	d.buf = l.data
+	n, err := d.p.finish(err)
+	if err != nil && err == l.readErr {
+		d.err = err
+		return nil, err
+	}
+	if d.err = d.rec.skip(); d.err != nil {
+		return nil, d.err
+	}
+	if err != nil {
+		return nil, d.fail(err)
+	}
+	if d.p.nodes == 0 {
+		return nil, nil // empty
+	}
+	d.record++
+	return n, nil
+}
+
+// first starts a value at the first token the parser reads.
+func (d *StreamDecoder) first(p *parser) (parseFunc, error) {
+	t, ok := p.next()
+	if !ok {
+		return nil, nil
+	}
+	d.start = t.pos()
+	p.ast, p.nodes = new(Node), 0
+	return p.value(t, ok)
+}
+
+// fail returns err as error of the current record.
+func (d *StreamDecoder) fail(err error) error {
+	e := &RecordError{Record: d.record, Line: d.start.Line, Err: err}
+	d.record++
+	return e
+}
+
+// recordReader reads the lines or records of r one after another. Read
+// returns io.EOF at the end of each one.
+type recordReader struct {
+	r     *bufio.Reader
+	delim byte
+	pos   Position // of the next byte
+	end   bool     // at the end of the current record
+	err   error    // io.EOF or the error of r
+}
+
+// next continues with the following record. It reports whether there is
+// one.
+func (r *recordReader) next() bool {
+	if r.err != nil {
+		return false
+	}
+	if _, err := r.r.Peek(1); err != nil {
+		r.err = err
+		return false
+	}
+	r.end = false
+	return true
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	if r.end || r.err != nil {
+		return 0, io.EOF
+	}
+	if r.r.Buffered() == 0 {
+		if _, err := r.r.Peek(1); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	b, _ := r.r.Peek(r.r.Buffered())
+	if len(b) > len(p) {
+		b = b[:len(p)]
+	}
+	if i := bytes.IndexByte(b, r.delim); i >= 0 {
+		b = b[:i+1]
+		r.end = true
+	}
+	r.advance(b)
+	n := copy(p, b)
+	r.r.Discard(n)
+	if r.end {
+		n-- // the delimiter
+	}
+	return n, nil
+}
+
+// skip discards the rest of the current record.
+func (r *recordReader) skip() error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+// advance moves pos over b.
+func (r *recordReader) advance(b []byte) {
+	for _, c := range b {
+		switch {
+		case c == '\n':
+			r.pos.Line++
+			r.pos.Column = 0
+		case c == '\r':
+			r.pos.Column = 0
+		case utf8.RuneStart(c):
+			r.pos.Column++
+		}
+	}
+	r.pos.Offset += len(b)
+}
+
+// StreamEncoder writes Nodes as JSON Lines.
+type StreamEncoder struct {
+	w io.Writer
+}
+
+// NewStreamEncoder creates a StreamEncoder writing to w.
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{w: w}
+}
+
+// Encode writes n like WriteJSON followed by a newline.
+func (e *StreamEncoder) Encode(n *Node) error {
+	_, err := n.format(e.w, formatOptions{trailer: "\n", stripComments: true})
+	return err
+}
@@ -1,6 +1,9 @@
 package airp
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 type tokenType uint8
 
@@ -17,12 +20,15 @@ const (
 	arrayCToken
 	objectOToken
 	objectCToken
+	identToken   // JSON5 identifier
+	commentToken // comment including its delimiters
 )
 
 type token struct {
 	Type     tokenType
 	value    string
 	position [2]int
+	span     [2]Position // start and end in the input
 }
 
 func newToken(b rune, r, c int) token {
@@ -44,6 +50,50 @@ func newToken(b rune, r, c int) token {
 	}
 }
 
+// identifier reports whether t is a JSON5 identifier. Only the lexer in
+// JSON5 mode sets the value of null, true and false tokens.
+func (t token) identifier() bool {
+	switch t.Type {
+	case identToken, nullToken, trueToken, falseToken:
+		return t.value != ""
+	default:
+		return false
+	}
+}
+
+// pos returns the position of t for the public API.
+func (t token) pos() Position {
+	return t.span[0]
+}
+
+// text returns t like it is written in the input for error messages.
+func (t token) text() string {
+	switch t.Type {
+	case nullToken:
+		return "null"
+	case trueToken:
+		return "true"
+	case falseToken:
+		return "false"
+	case stringToken:
+		return strconv.Quote(t.value)
+	case commaToken:
+		return ","
+	case colonToken:
+		return ":"
+	case arrayOToken:
+		return "["
+	case arrayCToken:
+		return "]"
+	case objectOToken:
+		return "{"
+	case objectCToken:
+		return "}"
+	default:
+		return t.value
+	}
+}
+
 // String generates a readable form of a token meant for debuging.
 func (t token) String() string {
 	switch t.Type {
@@ -69,6 +119,10 @@ func (t token) String() string {
 		return "<{>"
 	case objectCToken:
 		return "<}>"
+	case identToken:
+		return "<ident " + t.value + ">"
+	case commentToken:
+		return "<comment " + t.value + ">"
 	case errToken:
 		return "<err " + t.value + ">"
 	default:
@@ -0,0 +1,77 @@
+package airp
+
+import (
+	"errors"
+	"io"
+)
+
+// SkipSubtree is returned by the callbacks of a Handler to skip the array
+// or object that starts or the value of the member whose key was read.
+// Returned by other callbacks it is ignored.
+var SkipSubtree = errors.New("skip subtree")
+
+// Handler holds the callbacks of Walk. Each one is called with the path
+// of the value in the style of Node.Key and the token of the event.
+// Callbacks that are nil are not called. An error other than SkipSubtree
+// stops Walk which then returns it.
+type Handler struct {
+	OnObjectStart func(path string, t Token) error
+	OnObjectEnd   func(path string, t Token) error
+	OnArrayStart  func(path string, t Token) error
+	OnArrayEnd    func(path string, t Token) error
+	// OnKey gets the path of the value of the member.
+	OnKey func(path string, t Token) error
+	// OnValue is called for strings, numbers, booleans and null.
+	OnValue func(path string, t Token) error
+}
+
+// Walk reads the JSON document from r and calls the callbacks of h for it
+// in the order of the input. It does not build a tree.
+func Walk(r io.Reader, h Handler) error {
+	return ParseOptions{}.Walk(r, h)
+}
+
+// Walk reads the JSON document from r and calls the callbacks of h for it
+// in the order of the input. It does not build a tree.
+func (o ParseOptions) Walk(r io.Reader, h Handler) error {
+	d := o.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var on func(string, Token) error
+		n := len(d.stack) // frames in the path
+		switch {
+		case t.Kind == KeyKind:
+			on = h.OnKey
+		case t.Kind != DelimKind:
+			on = h.OnValue
+		case t.Value == "{":
+			on, n = h.OnObjectStart, n-1
+		case t.Value == "[":
+			on, n = h.OnArrayStart, n-1
+		case t.Value == "}":
+			on = h.OnObjectEnd
+		default:
+			on = h.OnArrayEnd
+		}
+		if on == nil {
+			continue
+		}
+		switch err := on(d.pathTo(n), t); {
+		case err == SkipSubtree && t.Kind == KeyKind:
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case err == SkipSubtree && (t.Value == "{" || t.Value == "["):
+			if err := d.skipOpen(); err != nil {
+				return err
+			}
+		case err != nil && err != SkipSubtree:
+			return err
+		}
+	}
+}